@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/kinvolk/lokomotive/pkg/terraform"
+)
+
+var clusterCmd = &cobra.Command{
+	Use:   "cluster",
+	Short: "Manage a Lokomotive cluster",
+}
+
+var clusterApplyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Apply the cluster's Terraform module and reconcile its DNS entries",
+	RunE:  runClusterApply,
+}
+
+func init() {
+	rootCmd.AddCommand(clusterCmd)
+	clusterCmd.AddCommand(clusterApplyCmd)
+
+	addDNSFlags(clusterApplyCmd)
+}
+
+func runClusterApply(cmd *cobra.Command, args []string) error {
+	zone := viper.GetString("zone")
+	if zone == "" {
+		return fmt.Errorf("--zone must be set")
+	}
+
+	ex, err := terraform.NewExecutor(viper.GetString("terraform-module-dir"))
+	if err != nil {
+		return fmt.Errorf("creating terraform executor: %w", err)
+	}
+
+	if _, err := ex.ExecuteSync("apply", "-auto-approve"); err != nil {
+		return fmt.Errorf("applying terraform module: %w", err)
+	}
+
+	// With --auto-dns, this reconciles DNS programmatically via pkg/dns.Apply
+	// instead of blocking on the interactive "Press Enter to check" loop.
+	return reconcileDNS(cmd.Context(), ex, zone)
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/kinvolk/lokomotive/pkg/components/drift"
+)
+
+var componentCmd = &cobra.Command{
+	Use:   "component",
+	Short: "Manage installed components",
+}
+
+var componentDiffCmd = &cobra.Command{
+	Use:     "diff [name...]",
+	Short:   "Show drift between rendered component manifests and the live cluster state",
+	PreRunE: doesKubeconfigExist,
+	RunE:    runComponentDiff,
+}
+
+var componentWatchCmd = &cobra.Command{
+	Use:     "watch [name...]",
+	Short:   "Continuously watch installed components for drift",
+	PreRunE: doesKubeconfigExist,
+	RunE:    runComponentWatch,
+}
+
+func init() {
+	rootCmd.AddCommand(componentCmd)
+	componentCmd.AddCommand(componentDiffCmd)
+	componentCmd.AddCommand(componentWatchCmd)
+
+	addKubeConfigFlag(componentCmd)
+	componentCmd.PersistentFlags().String("lokocfg-dir", ".", "Directory containing the cluster's lokocfg files")
+	viper.BindPFlag("lokocfg-dir", componentCmd.PersistentFlags().Lookup("lokocfg-dir"))
+
+	componentWatchCmd.Flags().Duration("interval", 30*time.Second, "How often to re-check components for drift")
+	viper.BindPFlag("interval", componentWatchCmd.Flags().Lookup("interval"))
+}
+
+func newDetector() (*drift.Detector, error) {
+	kubeconfig := viper.GetString("kubeconfig")
+
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("building kubeconfig: %w", err)
+	}
+
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("creating dynamic client: %w", err)
+	}
+
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("creating discovery client: %w", err)
+	}
+
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
+
+	configs, err := loadComponentConfigs(viper.GetString("lokocfg-dir"))
+	if err != nil {
+		return nil, fmt.Errorf("loading component configuration: %w", err)
+	}
+
+	// lokocfg bodies are self-contained HCL (no variable interpolation from
+	// the cluster block is needed to decode a component block), so an empty
+	// EvalContext is enough here.
+	return drift.NewDetector(client, mapper, configs, &hcl.EvalContext{}), nil
+}
+
+func runComponentDiff(cmd *cobra.Command, args []string) error {
+	d, err := newDetector()
+	if err != nil {
+		return err
+	}
+
+	diffs, err := d.Diff(args...)
+	if err != nil {
+		return fmt.Errorf("diffing components: %w", err)
+	}
+
+	if len(diffs) == 0 {
+		fmt.Println("No drift detected.")
+		return nil
+	}
+
+	for _, diff := range diffs {
+		fmt.Printf("%s: %s %s/%s has drifted:\n%s\n", diff.Component, diff.Kind, diff.Namespace, diff.Name, diff.Patch)
+	}
+
+	return nil
+}
+
+func runComponentWatch(cmd *cobra.Command, args []string) error {
+	interval := viper.GetDuration("interval")
+
+	d, err := newDetector()
+	if err != nil {
+		return err
+	}
+
+	for {
+		diffs, err := d.Diff(args...)
+		if err != nil {
+			return fmt.Errorf("diffing components: %w", err)
+		}
+
+		for _, diff := range diffs {
+			fmt.Printf("drift detected: %s: %s %s/%s\n", diff.Component, diff.Kind, diff.Namespace, diff.Name)
+		}
+
+		time.Sleep(interval)
+	}
+}
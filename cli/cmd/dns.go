@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/kinvolk/lokomotive/pkg/dns"
+	"github.com/kinvolk/lokomotive/pkg/terraform"
+)
+
+var dnsCmd = &cobra.Command{
+	Use:   "dns",
+	Short: "Manage DNS entries for a Lokomotive cluster",
+}
+
+var dnsApplyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Reconcile the DNS entries a Lokomotive cluster needs",
+	RunE:  runDNSApply,
+}
+
+func init() {
+	rootCmd.AddCommand(dnsCmd)
+	dnsCmd.AddCommand(dnsApplyCmd)
+
+	addDNSFlags(dnsApplyCmd)
+}
+
+func runDNSApply(cmd *cobra.Command, args []string) error {
+	zone := viper.GetString("zone")
+	if zone == "" {
+		return fmt.Errorf("--zone must be set")
+	}
+
+	ex, err := terraform.NewExecutor(viper.GetString("terraform-module-dir"))
+	if err != nil {
+		return fmt.Errorf("creating terraform executor: %w", err)
+	}
+
+	return reconcileDNS(cmd.Context(), ex, zone)
+}
+
+// dnsCredentialsFromEnv builds the provider credentials dns.Apply needs from
+// environment variables, so no new flags are required for running --auto-dns
+// in CI. Both providers' credentials are always filled in; dns.Apply only
+// uses the ones for the selected provider (e.g. Route53's for route53).
+func dnsCredentialsFromEnv() dns.Credentials {
+	return dns.Credentials{
+		Route53: &dns.Route53Credentials{
+			AccessKeyID:     viper.GetString("aws-access-key-id"),
+			SecretAccessKey: viper.GetString("aws-secret-access-key"),
+			Region:          viper.GetString("aws-region"),
+		},
+		Cloudflare: &dns.CloudflareCredentials{
+			APIToken: viper.GetString("cloudflare-api-token"),
+		},
+	}
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+)
+
+// componentBlockSchema matches top-level `component "name" { ... }` blocks in
+// a lokocfg file; everything else (the `cluster` block, variables, and so on)
+// is left alone so callers that only care about component configuration
+// don't need to know about the rest of the lokocfg grammar.
+var componentBlockSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{
+		{Type: "component", LabelNames: []string{"name"}},
+	},
+}
+
+// loadComponentConfigs parses every *.lokocfg file in dir and returns each
+// component block's body keyed by component name, so it can be passed to
+// components.Component.LoadConfig.
+// A component with no block in dir is simply absent from the returned map.
+func loadComponentConfigs(dir string) (map[string]*hcl.Body, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.lokocfg"))
+	if err != nil {
+		return nil, fmt.Errorf("globbing lokocfg files in %q: %w", dir, err)
+	}
+
+	parser := hclparse.NewParser()
+	configs := map[string]*hcl.Body{}
+
+	for _, file := range files {
+		f, diags := parser.ParseHCLFile(file)
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("parsing %q: %s", file, diags.Error())
+		}
+
+		content, _, diags := f.Body.PartialContent(componentBlockSchema)
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("reading component blocks from %q: %s", file, diags.Error())
+		}
+
+		for _, block := range content.Blocks {
+			body := block.Body
+			configs[block.Labels[0]] = &body
+		}
+	}
+
+	return configs, nil
+}
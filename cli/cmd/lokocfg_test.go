@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2/gohcl"
+)
+
+func TestLoadComponentConfigs(t *testing.T) {
+	dir := t.TempDir()
+
+	const lokocfg = `
+component "contour" {
+  service_type = "NodePort"
+}
+
+component "cluster-autoscaler" {
+  provider = "aws"
+}
+`
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "cluster.lokocfg"), []byte(lokocfg), 0o644); err != nil {
+		t.Fatalf("writing fixture lokocfg file: %v", err)
+	}
+
+	configs, err := loadComponentConfigs(dir)
+	if err != nil {
+		t.Fatalf("loadComponentConfigs() returned error: %v", err)
+	}
+
+	if len(configs) != 2 {
+		t.Fatalf("expected 2 component configs, got %d: %v", len(configs), configs)
+	}
+
+	body, ok := configs["contour"]
+	if !ok {
+		t.Fatal("expected a config body for \"contour\"")
+	}
+
+	var decoded struct {
+		ServiceType string `hcl:"service_type,optional"`
+	}
+
+	if diags := gohcl.DecodeBody(*body, nil, &decoded); diags.HasErrors() {
+		t.Fatalf("decoding \"contour\" body: %s", diags.Error())
+	}
+
+	if decoded.ServiceType != "NodePort" {
+		t.Fatalf("decoded ServiceType = %q, want %q", decoded.ServiceType, "NodePort")
+	}
+
+	if _, ok := configs["flatcar-linux-update-operator"]; ok {
+		t.Fatal("expected no config body for a component without a block")
+	}
+}
+
+func TestLoadComponentConfigsNoFiles(t *testing.T) {
+	configs, err := loadComponentConfigs(t.TempDir())
+	if err != nil {
+		t.Fatalf("loadComponentConfigs() returned error: %v", err)
+	}
+
+	if len(configs) != 0 {
+		t.Fatalf("expected no component configs, got %d", len(configs))
+	}
+}
@@ -1,11 +1,15 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+
+	"github.com/kinvolk/lokomotive/pkg/dns"
+	"github.com/kinvolk/lokomotive/pkg/terraform"
 )
 
 // doesKubeconfigExist checks if the kubeconfig provided by user exists
@@ -27,3 +31,44 @@ func addKubeConfigFlag(cmd *cobra.Command) {
 		"Path to kubeconfig file (required)")
 	viper.BindPFlag("kubeconfig", cmd.PersistentFlags().Lookup("kubeconfig"))
 }
+
+// addAutoDNSFlag adds the --auto-dns flag to cmd. When set, DNS entries are
+// reconciled programmatically via pkg/dns.Apply instead of the interactive
+// "press Enter to check" flow.
+func addAutoDNSFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().Bool(
+		"auto-dns",
+		false,
+		"Reconcile DNS entries automatically instead of prompting the operator")
+	viper.BindPFlag("auto-dns", cmd.PersistentFlags().Lookup("auto-dns"))
+}
+
+// addDNSFlags adds the --zone, --dns-provider and --terraform-module-dir
+// flags to cmd, plus --auto-dns via addAutoDNSFlag. Both `lokoctl dns apply`
+// and `lokoctl cluster apply` use these to decide how to reconcile DNS.
+func addDNSFlags(cmd *cobra.Command) {
+	cmd.Flags().String("zone", "", "DNS zone the cluster's entries live under (required)")
+	cmd.Flags().String("dns-provider", dns.DNSManual, "DNS provider: manual, route53 or cloudflare")
+	cmd.Flags().String("terraform-module-dir", ".", "Directory containing the cluster's rendered Terraform module")
+	addAutoDNSFlag(cmd)
+
+	viper.BindPFlag("zone", cmd.Flags().Lookup("zone"))
+	viper.BindPFlag("dns-provider", cmd.Flags().Lookup("dns-provider"))
+	viper.BindPFlag("terraform-module-dir", cmd.Flags().Lookup("terraform-module-dir"))
+}
+
+// reconcileDNS runs the DNS configuration step for ex/zone according to the
+// --auto-dns and --dns-provider flags added by addDNSFlags, so `lokoctl
+// cluster apply` and `lokoctl dns apply` share exactly the same decision.
+func reconcileDNS(ctx context.Context, ex *terraform.Executor, zone string) error {
+	provider := viper.GetString("dns-provider")
+	if err := dns.Validate(provider); err != nil {
+		return err
+	}
+
+	if !viper.GetBool("auto-dns") {
+		return dns.AskToConfigure(ex, zone)
+	}
+
+	return dns.Apply(ctx, ex, zone, provider, dnsCredentialsFromEnv())
+}
@@ -0,0 +1,120 @@
+// Command varnish-controller is the varnish component's sidecar: it watches
+// Ingress/HTTPProxy objects and the Envoy pods matching --backend-selector,
+// and keeps the varnish container's VCL in sync (see
+// pkg/components/varnish/controller). It is built into the
+// lokomotive/varnish-controller image referenced by the component's
+// DaemonSet template.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+
+	"github.com/kinvolk/lokomotive/pkg/components/varnish/controller"
+)
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "watch" {
+		fmt.Fprintln(os.Stderr, "usage: varnish-controller watch [flags]")
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+
+	vclPath := fs.String("vcl-path", "/etc/varnish/default.vcl", "Path to write the validated VCL configuration to")
+	socketPath := fs.String("socket-path", "/run/varnish-controller/reload.sock", "Unix socket to listen on for out-of-band reload requests")
+	reloadDebounce := fs.Duration("reload-debounce", 5*time.Second, "How often Ingress/HTTPProxy/backend pod churn is allowed to trigger a reload")
+	defaultTTL := fs.String("default-ttl", "120s", "TTL used for hosts without their own varnish.lokomotive.io/ttl annotation")
+	workDir := fs.String("workdir", "/var/lib/varnish", "varnishd instance workdir ('-n' flag) shared with the varnish container, used to reach it via varnishadm")
+
+	backendSelector := keyValueFlag{name: "backend-selector"}
+	fs.Var(&backendSelector, "backend-selector", "key=value label selecting the Envoy pods to use as backends; may be repeated")
+
+	hostTTLOverride := keyValueFlag{name: "host-ttl-override"}
+	fs.Var(&hostTTLOverride, "host-ttl-override", "host=ttl forcing a TTL for a host regardless of its varnish.lokomotive.io/ttl annotation; may be repeated")
+
+	if err := fs.Parse(os.Args[2:]); err != nil {
+		os.Exit(2)
+	}
+
+	if len(backendSelector.m) == 0 {
+		fmt.Fprintln(os.Stderr, "varnish-controller: at least one --backend-selector must be set")
+		os.Exit(2)
+	}
+
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "varnish-controller: building in-cluster config: %v\n", err)
+		os.Exit(1)
+	}
+
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "varnish-controller: creating dynamic client: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	c := controller.New(client, controller.Config{
+		VCLPath:          *vclPath,
+		SocketPath:       *socketPath,
+		ReloadDebounce:   *reloadDebounce,
+		DefaultTTL:       *defaultTTL,
+		BackendSelector:  backendSelector.m,
+		HostTTLOverrides: hostTTLOverride.m,
+		Namespace:        os.Getenv("POD_NAMESPACE"),
+		WorkDir:          *workDir,
+	})
+
+	if err := c.Run(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "varnish-controller: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// keyValueFlag accumulates repeated -flag=k=v flags into a map; name is the
+// flag's own name, used to make Set's error message useful regardless of
+// which flag it backs.
+type keyValueFlag struct {
+	name string
+	m    map[string]string
+}
+
+func (s *keyValueFlag) String() string {
+	if s == nil || len(s.m) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(s.m))
+	for k, v := range s.m {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func (s *keyValueFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid --%s %q, want key=value", s.name, value)
+	}
+
+	if s.m == nil {
+		s.m = map[string]string{}
+	}
+
+	s.m[parts[0]] = parts[1]
+
+	return nil
+}
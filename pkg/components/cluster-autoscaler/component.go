@@ -45,8 +45,14 @@ tolerations:
   operator: Exists
 rbac:
   create: true
+{{ if and (eq .Provider "aws") (not .AWS.AccessKeyID) }}
+  serviceAccount:
+    annotations:
+      eks.amazonaws.com/role-arn: {{ .AWS.IAMRoleARN }}
+{{ end }}
 cloudConfigPath: /config
 
+{{ if eq .Provider "packet" }}
 packetClusterName: {{ .ClusterName }}
 packetAuthToken: {{ .Packet.AuthToken }}
 packetCloudInit: {{ .Packet.UserData }}
@@ -58,11 +64,52 @@ autoscalingGroups:
 - name: {{ .WorkerPool }}
   maxSize: {{ .MaxWorkers }}
   minSize: {{ .MinWorkers }}
+{{ end }}
+
+{{ if eq .Provider "aws" }}
+awsRegion: {{ .AWS.Region }}
+{{ if .WorkerPool }}
+autoscalingGroups:
+- name: {{ .WorkerPool }}
+  maxSize: {{ .MaxWorkers }}
+  minSize: {{ .MinWorkers }}
+{{ else }}
+autoDiscovery:
+  clusterName: {{ .ClusterName }}
+{{ if .AWS.NodeGroupNameTemplate }}
+  nodeGroupNameTemplate: {{ .AWS.NodeGroupNameTemplate }}
+{{ end }}
+  tags:
+{{ range $k, $v := .AWS.ASGDiscoveryTags }}
+  - {{ $k }}={{ $v }}
+{{ end }}
+{{ end }}
+{{ if .AWS.AccessKeyID }}
+awsAccessKeyID: {{ .AWS.AccessKeyID }}
+awsSecretAccessKey: {{ .AWS.SecretAccessKey }}
+{{ end }}
+{{ end }}
+
+{{ if eq .Provider "openstack" }}
+cloudConfig: {{ .OpenStack.CloudConfig }}
+clusterName: {{ .ClusterName }}
+autoscalingGroups:
+{{ range .OpenStack.NodeGroups }}
+- name: {{ .Name }}
+  minSize: {{ .Min }}
+  maxSize: {{ .Max }}
+  flavor: {{ .Flavor }}
+  image: {{ .Image }}
+{{ end }}
+{{ end }}
 
 extraArgs:
   scale-down-unneeded-time: {{ .ScaleDownUnneededTime }}
   scale-down-delay-after-add: {{ .ScaleDownDelayAfterAdd }}
   scale-down-unready-time: {{ .ScaleDownUnreadyTime }}
+{{ if eq .Provider "aws" }}
+  balance-similar-node-groups: {{ .AWS.BalanceSimilarNodeGroups }}
+{{ end }}
 
 podDisruptionBudget: []
 kubeTargetVersionOverride: v1.17.2
@@ -102,6 +149,16 @@ type component struct {
 
 	// Packet-specific parameters
 	Packet *packetConfiguration `hcl:"packet,block"`
+
+	// AWS-specific parameters
+	AWS *awsConfiguration `hcl:"aws,block"`
+
+	// OpenStack-specific parameters
+	OpenStack *openstackConfiguration `hcl:"openstack,block"`
+
+	// Chart overrides the chart embedded in the lokoctl binary, e.g. to pin a
+	// newer upstream cluster-autoscaler release without rebuilding lokoctl.
+	Chart *util.ChartConfig `hcl:"chart,block"`
 }
 
 type packetConfiguration struct {
@@ -116,6 +173,54 @@ type packetConfiguration struct {
 	AuthToken     string
 }
 
+type awsConfiguration struct {
+	// required parameters
+	Region string `hcl:"region,optional"`
+
+	// ASG auto-discovery tags. When set, autoscaling groups are discovered by
+	// tag instead of requiring an explicit 'worker_pool'.
+	ASGDiscoveryTags map[string]string `hcl:"asg_discovery_tags,optional"`
+
+	// node group name template rendered as autoDiscovery.nodeGroupNameTemplate
+	// when no 'worker_pool' is set; RenderManifests defaults it to
+	// "<cluster_name>-worker-*".
+	NodeGroupNameTemplate string `hcl:"node_group_name_template,optional"`
+
+	// credentials; either an IAM role (for IRSA) or a static access key pair.
+	IAMRoleARN      string `hcl:"iam_role_arn,optional"`
+	AccessKeyID     string `hcl:"access_key_id,optional"`
+	SecretAccessKey string `hcl:"secret_access_key,optional"`
+
+	// optional parameters
+	BalanceSimilarNodeGroups bool `hcl:"balance_similar_node_groups,optional"`
+}
+
+type openstackConfiguration struct {
+	// required parameters
+	AuthURL   string `hcl:"auth_url,optional"`
+	Region    string `hcl:"region,optional"`
+	ProjectID string `hcl:"project_id,optional"`
+
+	// application credential pair used instead of a username/password.
+	ApplicationCredentialID     string `hcl:"application_credential_id,optional"`
+	ApplicationCredentialSecret string `hcl:"application_credential_secret,optional"`
+
+	// per-node-group flavor/image spec used by the Magnum-style autoscaler backend.
+	NodeGroups []openstackNodeGroup `hcl:"node_group,block"`
+
+	// CloudConfig is the base64-encoded clouds.yaml-style cloud-config generated
+	// from the fields above; it is computed in RenderManifests.
+	CloudConfig string
+}
+
+type openstackNodeGroup struct {
+	Name   string `hcl:"name,label"`
+	Flavor string `hcl:"flavor,optional"`
+	Image  string `hcl:"image,optional"`
+	Min    int    `hcl:"min,optional"`
+	Max    int    `hcl:"max,optional"`
+}
+
 func newComponent() *component {
 	c := &component{
 		Provider:               "packet",
@@ -172,6 +277,24 @@ func getWorkerUserdata(clusterName, facility string, devices []packngo.Device) (
 	return userData, nil
 }
 
+// renderOpenStackCloudConfig builds the cloud-config consumed by the Magnum-style
+// autoscaler backend from the application credential configured for the
+// component. Unlike pkg/platform/openstack's Cinder CSI cloud-config, this one
+// is computed directly from the "openstack" block's HCL fields rather than
+// read back from a Terraform output: components.Component.RenderManifests
+// takes no *terraform.Executor (unlike platform.Platform's), and the
+// autoscaler's Magnum cloud-config isn't something any Terraform module here
+// applies or exports as an output in the first place.
+func renderOpenStackCloudConfig(c *openstackConfiguration) string {
+	return fmt.Sprintf(`[Global]
+auth-url=%s
+region=%s
+tenant-id=%s
+application-credential-id=%s
+application-credential-secret=%s
+`, c.AuthURL, c.Region, c.ProjectID, c.ApplicationCredentialID, c.ApplicationCredentialSecret)
+}
+
 // parseDurations takes the raw string time parameters from component and sets
 // parsed time.Duration parameters.
 func (c *component) parseDurations() hcl.Diagnostics {
@@ -240,9 +363,13 @@ func (c *component) LoadConfig(configBody *hcl.Body, evalContext *hcl.EvalContex
 	switch c.Provider {
 	case "packet":
 		diagnostics = c.validatePacket(diagnostics)
+	case "aws":
+		diagnostics = c.validateAWS(diagnostics)
+	case "openstack":
+		diagnostics = c.validateOpenStack(diagnostics)
 	default:
 		// Slice can't be constant, so just use a variable
-		supportedProviders := []string{"packet"}
+		supportedProviders := []string{"packet", "aws", "openstack"}
 		diagnostics = append(diagnostics, &hcl.Diagnostic{
 			Severity: hcl.DiagError,
 			Summary:  "Make sure to set provider to one of supported values",
@@ -250,7 +377,12 @@ func (c *component) LoadConfig(configBody *hcl.Body, evalContext *hcl.EvalContex
 		})
 	}
 
-	if c.WorkerPool == "" {
+	// On AWS, ASGs can be discovered by tag, so 'worker_pool' is only required
+	// when auto-discovery tags have not been supplied. On OpenStack, node groups
+	// are listed explicitly via 'node_group' blocks instead.
+	workerPoolOptional := (c.Provider == "aws" && c.AWS != nil && len(c.AWS.ASGDiscoveryTags) > 0) ||
+		c.Provider == "openstack"
+	if c.WorkerPool == "" && !workerPoolOptional {
 		diagnostics = append(diagnostics, &hcl.Diagnostic{
 			Severity: hcl.DiagError,
 			Summary:  "'worker_pool' must be set",
@@ -266,6 +398,8 @@ func (c *component) LoadConfig(configBody *hcl.Body, evalContext *hcl.EvalContex
 		})
 	}
 
+	diagnostics = util.ValidateChart(c.Chart, diagnostics)
+
 	return diagnostics
 }
 
@@ -298,10 +432,102 @@ func (c *component) validatePacket(diagnostics hcl.Diagnostics) hcl.Diagnostics
 	return diagnostics
 }
 
+func (c *component) validateAWS(diagnostics hcl.Diagnostics) hcl.Diagnostics {
+	if c.AWS == nil {
+		c.AWS = &awsConfiguration{}
+		diagnostics = append(diagnostics, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "'aws' block must exist",
+			Detail:   "When using AWS provider, 'aws' block must exist",
+		})
+	}
+
+	if c.AWS.Region == "" {
+		diagnostics = append(diagnostics, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "'region' must be set",
+			Detail:   "When using AWS provider, 'region' must be set but it was not found",
+		})
+	}
+
+	if c.AWS.AccessKeyID != "" && c.AWS.SecretAccessKey == "" {
+		diagnostics = append(diagnostics, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "'secret_access_key' must be set",
+			Detail:   "When 'access_key_id' is set, 'secret_access_key' must be set as well",
+		})
+	}
+
+	return diagnostics
+}
+
+func (c *component) validateOpenStack(diagnostics hcl.Diagnostics) hcl.Diagnostics {
+	if c.OpenStack == nil {
+		c.OpenStack = &openstackConfiguration{}
+		diagnostics = append(diagnostics, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "'openstack' block must exist",
+			Detail:   "When using OpenStack provider, 'openstack' block must exist",
+		})
+	}
+
+	if c.OpenStack.AuthURL == "" {
+		diagnostics = append(diagnostics, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "'auth_url' must be set",
+			Detail:   "When using OpenStack provider, 'auth_url' must be set but it was not found",
+		})
+	}
+
+	if c.OpenStack.ProjectID == "" {
+		diagnostics = append(diagnostics, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "'project_id' must be set",
+			Detail:   "When using OpenStack provider, 'project_id' must be set but it was not found",
+		})
+	}
+
+	if c.OpenStack.ApplicationCredentialID == "" || c.OpenStack.ApplicationCredentialSecret == "" {
+		diagnostics = append(diagnostics, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "application credentials must be set",
+			Detail:   "When using OpenStack provider, 'application_credential_id' and 'application_credential_secret' must both be set",
+		})
+	}
+
+	if len(c.OpenStack.NodeGroups) == 0 {
+		diagnostics = append(diagnostics, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "at least one 'node_group' block must be set",
+			Detail:   "When using OpenStack provider, at least one 'node_group' block must be set",
+		})
+	}
+
+	for _, ng := range c.OpenStack.NodeGroups {
+		if ng.Flavor == "" {
+			diagnostics = append(diagnostics, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "'flavor' must be set",
+				Detail:   fmt.Sprintf("node_group %q must set 'flavor'", ng.Name),
+			})
+		}
+
+		if ng.Image == "" {
+			diagnostics = append(diagnostics, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "'image' must be set",
+				Detail:   fmt.Sprintf("node_group %q must set 'image'", ng.Name),
+			})
+		}
+	}
+
+	return diagnostics
+}
+
 func (c *component) RenderManifests() (map[string]string, error) {
-	helmChart, err := util.LoadChartFromAssets(fmt.Sprintf("/components/%s", name))
+	helmChart, err := c.Chart.Source(fmt.Sprintf("/components/%s", name)).Load()
 	if err != nil {
-		return nil, errors.Wrap(err, "load chart from assets")
+		return nil, errors.Wrap(err, "load chart")
 	}
 
 	if c.Provider == "packet" {
@@ -324,6 +550,14 @@ func (c *component) RenderManifests() (map[string]string, error) {
 		c.Packet.AuthToken = base64.StdEncoding.EncodeToString([]byte(os.Getenv("PACKET_AUTH_TOKEN")))
 	}
 
+	if c.Provider == "aws" && c.WorkerPool == "" && c.AWS.NodeGroupNameTemplate == "" {
+		c.AWS.NodeGroupNameTemplate = fmt.Sprintf("%s-worker-*", c.ClusterName)
+	}
+
+	if c.Provider == "openstack" {
+		c.OpenStack.CloudConfig = base64.StdEncoding.EncodeToString([]byte(renderOpenStackCloudConfig(c.OpenStack)))
+	}
+
 	values, err := utilpkg.RenderTemplate(chartValuesTmpl, c)
 	if err != nil {
 		return nil, errors.Wrap(err, "render chart values template")
@@ -0,0 +1,131 @@
+package clusterautoscaler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+
+	utilpkg "github.com/kinvolk/lokomotive/pkg/util"
+)
+
+func TestChartValuesTmplAWSNodeGroupsStaticCredentials(t *testing.T) {
+	c := &component{
+		Provider:    "aws",
+		ClusterName: "test-cluster",
+		WorkerPool:  "workers",
+		MaxWorkers:  5,
+		MinWorkers:  1,
+		AWS: &awsConfiguration{
+			Region:          "us-east-1",
+			AccessKeyID:     "AKIATEST",
+			SecretAccessKey: "s3cr3t",
+		},
+	}
+
+	values, err := utilpkg.RenderTemplate(chartValuesTmpl, c)
+	if err != nil {
+		t.Fatalf("rendering chartValuesTmpl: %v", err)
+	}
+
+	for _, want := range []string{
+		"awsRegion: us-east-1",
+		"name: workers",
+		"awsAccessKeyID: AKIATEST",
+		"awsSecretAccessKey: s3cr3t",
+	} {
+		if !strings.Contains(values, want) {
+			t.Errorf("rendered values missing %q:\n%s", want, values)
+		}
+	}
+
+	if strings.Contains(values, "eks.amazonaws.com/role-arn") {
+		t.Errorf("did not expect an IRSA role annotation when static credentials are set, got:\n%s", values)
+	}
+}
+
+func TestChartValuesTmplAWSNodeGroupsIRSAAutoDiscovery(t *testing.T) {
+	c := &component{
+		Provider:    "aws",
+		ClusterName: "test-cluster",
+		AWS: &awsConfiguration{
+			Region:     "us-east-1",
+			IAMRoleARN: "arn:aws:iam::123456789012:role/cluster-autoscaler",
+			ASGDiscoveryTags: map[string]string{
+				"k8s.io/cluster-autoscaler/enabled": "true",
+			},
+		},
+	}
+
+	values, err := utilpkg.RenderTemplate(chartValuesTmpl, c)
+	if err != nil {
+		t.Fatalf("rendering chartValuesTmpl: %v", err)
+	}
+
+	for _, want := range []string{
+		"eks.amazonaws.com/role-arn: arn:aws:iam::123456789012:role/cluster-autoscaler",
+		"clusterName: test-cluster",
+		"k8s.io/cluster-autoscaler/enabled=true",
+	} {
+		if !strings.Contains(values, want) {
+			t.Errorf("rendered values missing %q:\n%s", want, values)
+		}
+	}
+
+	if strings.Contains(values, "autoscalingGroups:\n- name:") {
+		t.Errorf("did not expect an explicit autoscalingGroups entry with auto-discovery, got:\n%s", values)
+	}
+}
+
+func TestValidateAWSRequiresRegionAndSecretAccessKey(t *testing.T) {
+	c := &component{
+		Provider: "aws",
+		AWS: &awsConfiguration{
+			AccessKeyID: "AKIATEST",
+		},
+	}
+
+	diagnostics := c.validateAWS(hcl.Diagnostics{})
+
+	if len(diagnostics) != 2 {
+		t.Fatalf("expected 2 diagnostics (missing region, missing secret_access_key), got %d: %v", len(diagnostics), diagnostics)
+	}
+}
+
+func TestValidateAWSNilBlock(t *testing.T) {
+	c := &component{Provider: "aws"}
+
+	diagnostics := c.validateAWS(hcl.Diagnostics{})
+
+	if len(diagnostics) != 2 {
+		t.Fatalf("expected 2 diagnostics ('aws' block must exist, missing region), got %d: %v", len(diagnostics), diagnostics)
+	}
+
+	if c.AWS == nil {
+		t.Fatalf("expected validateAWS to initialize an empty AWS config so later field accesses don't panic")
+	}
+}
+
+func TestChartValuesTmplOpenStackNodeGroups(t *testing.T) {
+	c := &component{
+		Provider:    "openstack",
+		ClusterName: "test-cluster",
+		OpenStack: &openstackConfiguration{
+			CloudConfig: "base64-cloud-config",
+			NodeGroups: []openstackNodeGroup{
+				{Name: "workers", Flavor: "m1.large", Image: "flatcar-stable", Min: 1, Max: 5},
+			},
+		},
+	}
+
+	values, err := utilpkg.RenderTemplate(chartValuesTmpl, c)
+	if err != nil {
+		t.Fatalf("rendering chartValuesTmpl: %v", err)
+	}
+
+	for _, want := range []string{"name: workers", "flavor: m1.large", "image: flatcar-stable"} {
+		if !strings.Contains(values, want) {
+			t.Errorf("rendered values missing %q:\n%s", want, values)
+		}
+	}
+}
@@ -16,9 +16,12 @@ package contour
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/gohcl"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
 
 	"github.com/kinvolk/lokomotive/internal"
 	internaltemplate "github.com/kinvolk/lokomotive/internal/template"
@@ -50,9 +53,18 @@ type component struct {
 	IngressHosts    []string            `hcl:"ingress_hosts,optional"`
 	NodeAffinity    []util.NodeAffinity `hcl:"node_affinity,block"`
 	NodeAffinityRaw string
-	ServiceType     string            `hcl:"service_type,optional"`
-	Tolerations     []util.Toleration `hcl:"toleration,block"`
-	TolerationsRaw  string
+	ServiceType     string `hcl:"service_type,optional"`
+	// ServiceAnnotations is merged into the Envoy Service's annotations. On
+	// OpenStack, this is how an operator requests an Octavia-backed load
+	// balancer with non-default settings, e.g.
+	// loadbalancer.openstack.org/load-balancer-method = "ROUND_ROBIN" or
+	// loadbalancer.openstack.org/floating-network-id for a floating IP.
+	ServiceAnnotations map[string]string `hcl:"service_annotations,optional"`
+	Tolerations        []util.Toleration `hcl:"toleration,block"`
+	TolerationsRaw     string
+	// Chart overrides the chart embedded in the lokoctl binary, e.g. to pin a
+	// newer upstream Contour release without rebuilding lokoctl.
+	Chart *util.ChartConfig `hcl:"chart,block"`
 }
 
 func newComponent() *component {
@@ -84,13 +96,15 @@ func (c *component) LoadConfig(configBody *hcl.Body, evalContext *hcl.EvalContex
 		})
 	}
 
+	diagnostics = util.ValidateChart(c.Chart, diagnostics)
+
 	return diagnostics
 }
 
 func (c *component) RenderManifests() (map[string]string, error) {
-	helmChart, err := util.LoadChartFromAssets("/components/contour")
+	helmChart, err := c.Chart.Source("/components/contour").Load()
 	if err != nil {
-		return nil, fmt.Errorf("load chart from assets: %w", err)
+		return nil, fmt.Errorf("load chart: %w", err)
 	}
 
 	c.TolerationsRaw, err = util.RenderTolerations(c.Tolerations)
@@ -114,9 +128,57 @@ func (c *component) RenderManifests() (map[string]string, error) {
 		return nil, fmt.Errorf("rendering chart failed: %w", err)
 	}
 
+	// The embedded chart's values don't expose a knob for extra Service
+	// annotations, so apply them as a structural patch after rendering,
+	// instead of a chartValuesTmpl placeholder.
+	if err := patchServiceAnnotations(renderedFiles, c.ServiceAnnotations); err != nil {
+		return nil, fmt.Errorf("patching Envoy service annotations: %w", err)
+	}
+
 	return renderedFiles, nil
 }
 
+// patchServiceAnnotations merges annotations into the rendered Envoy Service's
+// annotations, so operators can request e.g. an Octavia-backed load balancer
+// with non-default settings via 'service_annotations'.
+func patchServiceAnnotations(manifests map[string]string, annotations map[string]string) error {
+	if len(annotations) == 0 {
+		return nil
+	}
+
+	for path, manifest := range manifests {
+		var obj unstructured.Unstructured
+		if err := yaml.Unmarshal([]byte(manifest), &obj.Object); err != nil {
+			// Not every file in the rendered chart is a Kubernetes manifest.
+			continue
+		}
+
+		if obj.GetKind() != "Service" || !strings.Contains(obj.GetName(), "envoy") {
+			continue
+		}
+
+		merged := obj.GetAnnotations()
+		if merged == nil {
+			merged = map[string]string{}
+		}
+
+		for k, v := range annotations {
+			merged[k] = v
+		}
+
+		obj.SetAnnotations(merged)
+
+		patched, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return fmt.Errorf("marshaling patched %q: %w", path, err)
+		}
+
+		manifests[path] = string(patched)
+	}
+
+	return nil
+}
+
 func (c *component) Metadata() components.Metadata {
 	return components.Metadata{
 		Name:      name,
@@ -0,0 +1,66 @@
+package contour
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+func TestPatchServiceAnnotations(t *testing.T) {
+	const envoyService = `
+apiVersion: v1
+kind: Service
+metadata:
+  name: envoy
+  namespace: projectcontour
+  annotations:
+    existing: keep-me
+`
+
+	manifests := map[string]string{
+		"templates/envoy-service.yaml": envoyService,
+		"templates/NOTES.txt":          "thanks for installing contour",
+	}
+
+	annotations := map[string]string{
+		"loadbalancer.openstack.org/load-balancer-method": "ROUND_ROBIN",
+	}
+
+	if err := patchServiceAnnotations(manifests, annotations); err != nil {
+		t.Fatalf("patchServiceAnnotations() returned error: %v", err)
+	}
+
+	var svc unstructured.Unstructured
+	if err := yaml.Unmarshal([]byte(manifests["templates/envoy-service.yaml"]), &svc.Object); err != nil {
+		t.Fatalf("unmarshaling patched Service: %v", err)
+	}
+
+	got := svc.GetAnnotations()
+
+	if got["existing"] != "keep-me" {
+		t.Errorf("existing annotation was dropped: %v", got)
+	}
+
+	if got["loadbalancer.openstack.org/load-balancer-method"] != "ROUND_ROBIN" {
+		t.Errorf("expected Octavia annotation to be merged, got: %v", got)
+	}
+
+	if manifests["templates/NOTES.txt"] != "thanks for installing contour" {
+		t.Errorf("non-manifest file was modified: %q", manifests["templates/NOTES.txt"])
+	}
+}
+
+func TestPatchServiceAnnotationsNoop(t *testing.T) {
+	manifests := map[string]string{
+		"templates/envoy-service.yaml": "apiVersion: v1\nkind: Service\nmetadata:\n  name: envoy\n",
+	}
+
+	if err := patchServiceAnnotations(manifests, nil); err != nil {
+		t.Fatalf("patchServiceAnnotations() returned error: %v", err)
+	}
+
+	if manifests["templates/envoy-service.yaml"] != "apiVersion: v1\nkind: Service\nmetadata:\n  name: envoy\n" {
+		t.Errorf("manifest should be untouched when no annotations are configured")
+	}
+}
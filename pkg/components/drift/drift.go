@@ -0,0 +1,284 @@
+// Copyright 2020 The Lokomotive Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package drift diffs the manifests rendered by installed components against the
+// live objects in the cluster, so hand-edits to rendered resources (e.g. a
+// manually patched Envoy service or autoscaling group) surface instead of going
+// unnoticed.
+package drift
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+
+	"github.com/kinvolk/lokomotive/pkg/components"
+)
+
+var eventsGVR = schema.GroupVersionResource{Version: "v1", Resource: "events"}
+
+// Diff describes the drift detected for a single rendered resource.
+type Diff struct {
+	Component string
+	Namespace string
+	Name      string
+	Kind      string
+	// Patch is a "--- rendered\n+++ live" header followed by the whole
+	// normalized live object as YAML, or by the rendered object as YAML when
+	// the object is not installed. It does not single out the fields that
+	// differ.
+	Patch string
+}
+
+// Detector diffs every registered component's rendered manifests against the
+// live cluster state.
+type Detector struct {
+	client dynamic.Interface
+	mapper meta.RESTMapper
+
+	// configs holds the HCL configuration body for each component, as found in
+	// the cluster's lokocfg files. A component missing from this map is
+	// loaded from an empty config block, so it keeps its default settings.
+	configs     map[string]*hcl.Body
+	evalContext *hcl.EvalContext
+}
+
+// NewDetector returns a Detector that talks to the cluster identified by client,
+// resolving rendered Kinds to REST resources via mapper. configs and evalContext
+// are used to load each component's real HCL configuration before rendering it;
+// pass a nil map and nil evalContext to render every component with its defaults.
+func NewDetector(client dynamic.Interface, mapper meta.RESTMapper, configs map[string]*hcl.Body, evalContext *hcl.EvalContext) *Detector {
+	return &Detector{client: client, mapper: mapper, configs: configs, evalContext: evalContext}
+}
+
+// Diff renders the manifests for the given component names (all registered
+// components when names is empty) and compares each rendered resource against
+// its live counterpart. A component that fails to load its configuration,
+// fails to render or fails to diff is recorded in the returned error but does
+// not stop the remaining components from being checked.
+func (d *Detector) Diff(names ...string) ([]Diff, error) {
+	var diffs []Diff
+	var failures []string
+
+	for componentName, component := range components.List() {
+		if len(names) > 0 && !contains(names, componentName) {
+			continue
+		}
+
+		configBody := d.configs[componentName]
+		if configBody == nil {
+			emptyConfig := hcl.EmptyBody()
+			configBody = &emptyConfig
+		}
+
+		if diag := component.LoadConfig(configBody, d.evalContext); diag.HasErrors() {
+			failures = append(failures, fmt.Sprintf("loading config for component %q: %s", componentName, diag.Error()))
+			continue
+		}
+
+		manifests, err := component.RenderManifests()
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("rendering manifests for component %q: %v", componentName, err))
+			continue
+		}
+
+		for path, manifest := range manifests {
+			diff, err := d.diffManifest(componentName, path, manifest)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("diffing %q for component %q: %v", path, componentName, err))
+				continue
+			}
+
+			if diff == nil {
+				continue
+			}
+
+			diffs = append(diffs, *diff)
+
+			if err := d.emitDriftEvent(*diff); err != nil {
+				failures = append(failures, fmt.Sprintf("emitting drift event for %s/%s: %v", diff.Namespace, diff.Name, err))
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		return diffs, fmt.Errorf("drift check reported errors:\n%s", strings.Join(failures, "\n"))
+	}
+
+	return diffs, nil
+}
+
+// emitDriftEvent records diff as a Kubernetes Event in the drifted resource's
+// namespace, so drift is visible via `kubectl get events` and not just lokoctl's
+// own output.
+func (d *Detector) emitDriftEvent(diff Diff) error {
+	now := metav1.Now().Format(time.RFC3339)
+
+	event := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Event",
+			"metadata": map[string]interface{}{
+				"generateName": fmt.Sprintf("%s-drift-", diff.Name),
+				"namespace":    diff.Namespace,
+			},
+			"involvedObject": map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       diff.Kind,
+				"name":       diff.Name,
+				"namespace":  diff.Namespace,
+			},
+			"reason":         "ComponentDrift",
+			"message":        fmt.Sprintf("component %q has drifted from its rendered manifest", diff.Component),
+			"type":           "Warning",
+			"count":          int64(1),
+			"firstTimestamp": now,
+			"lastTimestamp":  now,
+			"source": map[string]interface{}{
+				"component": "lokoctl-component-drift",
+			},
+		},
+	}
+
+	_, err := d.client.Resource(eventsGVR).Namespace(diff.Namespace).Create(event, metav1.CreateOptions{})
+
+	return err
+}
+
+func (d *Detector) diffManifest(componentName, path, manifest string) (*Diff, error) {
+	var rendered unstructured.Unstructured
+	if err := yaml.Unmarshal([]byte(manifest), &rendered.Object); err != nil {
+		// Not every file in a rendered chart is a Kubernetes manifest (e.g. NOTES.txt).
+		return nil, nil //nolint:nilnil
+	}
+
+	if rendered.GetKind() == "" {
+		return nil, nil //nolint:nilnil
+	}
+
+	live, err := d.getLive(&rendered)
+	if err != nil {
+		return nil, err
+	}
+
+	if live == nil {
+		normalize(&rendered)
+
+		renderedYAML, err := yaml.Marshal(rendered.Object)
+		if err != nil {
+			return nil, errors.Wrap(err, "marshaling rendered object")
+		}
+
+		return &Diff{
+			Component: componentName,
+			Namespace: rendered.GetNamespace(),
+			Name:      rendered.GetName(),
+			Kind:      rendered.GetKind(),
+			Patch:     fmt.Sprintf("--- rendered\n+++ live\n%s/%s is not installed:\n%s", rendered.GetKind(), rendered.GetName(), renderedYAML),
+		}, nil
+	}
+
+	normalize(live)
+	normalize(&rendered)
+
+	patch, err := comparePatch(&rendered, live)
+	if err != nil {
+		return nil, err
+	}
+
+	if patch == "" {
+		return nil, nil
+	}
+
+	return &Diff{
+		Component: componentName,
+		Namespace: rendered.GetNamespace(),
+		Name:      rendered.GetName(),
+		Kind:      rendered.GetKind(),
+		Patch:     patch,
+	}, nil
+}
+
+func (d *Detector) getLive(rendered *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	gvk := rendered.GroupVersionKind()
+
+	mapping, err := d.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolving REST mapping for %s", gvk)
+	}
+
+	live, err := d.client.Resource(mapping.Resource).Namespace(rendered.GetNamespace()).Get(rendered.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		// The component hasn't been applied yet (or the object was deleted):
+		// report it as fully drifted instead of failing the whole run.
+		return nil, nil //nolint:nilnil
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting live object %s/%s", rendered.GetNamespace(), rendered.GetName())
+	}
+
+	return live, nil
+}
+
+// normalize drops fields that are populated by the API server and would
+// otherwise always show up as drift: status, managed fields and generation
+// metadata.
+func normalize(u *unstructured.Unstructured) {
+	unstructured.RemoveNestedField(u.Object, "status")
+	unstructured.RemoveNestedField(u.Object, "metadata", "managedFields")
+	unstructured.RemoveNestedField(u.Object, "metadata", "generation")
+	unstructured.RemoveNestedField(u.Object, "metadata", "resourceVersion")
+	unstructured.RemoveNestedField(u.Object, "metadata", "uid")
+	unstructured.RemoveNestedField(u.Object, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(u.Object, "metadata", "selfLink")
+}
+
+func comparePatch(rendered, live *unstructured.Unstructured) (string, error) {
+	renderedYAML, err := yaml.Marshal(rendered.Object)
+	if err != nil {
+		return "", errors.Wrap(err, "marshaling rendered object")
+	}
+
+	liveYAML, err := yaml.Marshal(live.Object)
+	if err != nil {
+		return "", errors.Wrap(err, "marshaling live object")
+	}
+
+	if string(renderedYAML) == string(liveYAML) {
+		return "", nil
+	}
+
+	return fmt.Sprintf("--- rendered\n+++ live\n%s", liveYAML), nil
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
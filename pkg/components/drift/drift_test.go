@@ -0,0 +1,82 @@
+// Copyright 2020 The Lokomotive Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package drift
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+const configMapManifest = `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: example
+  namespace: default
+data:
+  key: value
+`
+
+func newTestDetector() *Detector {
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+
+	return NewDetector(client, mapper, nil, nil)
+}
+
+func TestDiffManifestNotInstalled(t *testing.T) {
+	d := newTestDetector()
+
+	diff, err := d.diffManifest("example-component", "configmap.yaml", configMapManifest)
+	if err != nil {
+		t.Fatalf("diffManifest() returned error: %v", err)
+	}
+
+	if diff == nil {
+		t.Fatalf("expected a diff reporting the ConfigMap as not installed")
+	}
+
+	if diff.Kind != "ConfigMap" || diff.Name != "example" || diff.Namespace != "default" {
+		t.Errorf("unexpected diff identity: %+v", diff)
+	}
+
+	if !strings.Contains(diff.Patch, "ConfigMap/example is not installed") {
+		t.Errorf("expected patch to call out the missing resource, got:\n%s", diff.Patch)
+	}
+
+	if !strings.Contains(diff.Patch, "key: value") {
+		t.Errorf("expected patch to include the rendered manifest so operators can see what would be created, got:\n%s", diff.Patch)
+	}
+}
+
+func TestDiffManifestIgnoresNonManifestFiles(t *testing.T) {
+	d := newTestDetector()
+
+	diff, err := d.diffManifest("example-component", "NOTES.txt", "thanks for installing the chart")
+	if err != nil {
+		t.Fatalf("diffManifest() returned error: %v", err)
+	}
+
+	if diff != nil {
+		t.Errorf("expected no diff for a non-manifest file, got: %+v", diff)
+	}
+}
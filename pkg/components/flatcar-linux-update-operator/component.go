@@ -1,43 +1,257 @@
+// Copyright 2020 The Lokomotive Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
 package flatcarlinuxupdateoperator
 
 import (
+	"bytes"
 	"fmt"
+	"sort"
+	"strings"
+	"text/template"
 
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
 
-	"github.com/kinvolk/lokoctl/pkg/assets"
-	"github.com/kinvolk/lokoctl/pkg/components"
-	"github.com/kinvolk/lokoctl/pkg/util/walkers"
+	"github.com/kinvolk/lokomotive/pkg/assets"
+	"github.com/kinvolk/lokomotive/pkg/components"
+	"github.com/kinvolk/lokomotive/pkg/components/util"
 )
 
 const componentName = "flatcar-linux-update-operator"
 
 func init() {
-	components.Register(componentName, &component{})
+	components.Register(componentName, newComponent())
 }
 
-type component struct{}
+// component configures the FLUO operator and agent Deployments/DaemonSets.
+type component struct {
+	RebootWindow *rebootWindow `hcl:"reboot_window,block"`
+
+	MaxUnavailableNodes    string            `hcl:"max_unavailable_nodes,optional"`
+	NodeAnnotationSelector map[string]string `hcl:"node_annotation_selectors,optional"`
+
+	NodeAffinity    []util.NodeAffinity `hcl:"node_affinity,block"`
+	NodeAffinityRaw string
+	Tolerations     []util.Toleration `hcl:"toleration,block"`
+	TolerationsRaw  string
+
+	// LockBeforeReboot wires up the etcd-lock daemonset, so a node only reboots
+	// once it has acquired a lock in etcd.
+	LockBeforeReboot bool `hcl:"lock_before_reboot,optional"`
+}
+
+type rebootWindow struct {
+	Start  string `hcl:"start,optional"`
+	Length string `hcl:"length,optional"`
+}
+
+func newComponent() *component {
+	return &component{
+		MaxUnavailableNodes: "1",
+	}
+}
 
 func (c *component) LoadConfig(configBody *hcl.Body, evalContext *hcl.EvalContext) hcl.Diagnostics {
 	if configBody == nil {
-		// This component has no configuration, so don't complain when there is no configuration defined.
+		// This component has no required configuration, so don't complain when there is none defined.
 		return nil
 	}
+
 	return gohcl.DecodeBody(*configBody, evalContext, c)
 }
 
 func (c *component) RenderManifests() (map[string]string, error) {
+	var err error
+
+	c.TolerationsRaw, err = util.RenderTolerations(c.Tolerations)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal operator tolerations")
+	}
+
+	c.NodeAffinityRaw, err = util.RenderNodeAffinity(c.NodeAffinity)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal node affinity")
+	}
+
 	ret := make(map[string]string)
-	walk := walkers.DumpingWalker(ret, ".yaml")
+
+	walk := func(path string, contents []byte) error {
+		rendered, err := renderManifest(path, contents, c)
+		if err != nil {
+			return errors.Wrapf(err, "rendering %q", path)
+		}
+
+		ret[path] = rendered
+
+		return nil
+	}
+
 	if err := assets.Assets.WalkFiles(fmt.Sprintf("/components/%s/manifests", componentName), walk); err != nil {
 		return nil, errors.Wrap(err, "failed to walk assets")
 	}
 
+	// The static manifests only expose placeholders for a handful of simple
+	// values. Node affinity/tolerations and the reboot-window/etcd-lock knobs
+	// need structural changes to the Deployment/DaemonSet objects (merging a
+	// map into spec.template.spec, or dropping a whole object), which a single
+	// text/template pass over the raw YAML can't express. Patch the rendered
+	// objects directly instead, so these settings take effect regardless of
+	// what the static manifests' own placeholders cover.
+	if err := c.patchManifests(ret); err != nil {
+		return nil, errors.Wrap(err, "patching rendered manifests")
+	}
+
 	return ret, nil
 }
 
+// patchManifests applies c's configuration to the rendered Deployment/DaemonSet
+// objects in place: it merges in node affinity and tolerations, sets the
+// reboot-window/max-unavailable-nodes/node-annotation-selector operator
+// arguments, and drops the etcd-lock DaemonSet unless lock_before_reboot is set.
+func (c *component) patchManifests(manifests map[string]string) error {
+	for path, manifest := range manifests {
+		var obj unstructured.Unstructured
+		if err := yaml.Unmarshal([]byte(manifest), &obj.Object); err != nil {
+			// Not every file in the manifest set is a Kubernetes object (e.g. a
+			// ServiceAccount has nothing for us to patch, but still parses fine).
+			continue
+		}
+
+		kind := obj.GetKind()
+		if kind != "Deployment" && kind != "DaemonSet" {
+			continue
+		}
+
+		if strings.Contains(strings.ToLower(obj.GetName()), "etcd-lock") {
+			if !c.LockBeforeReboot {
+				delete(manifests, path)
+			}
+
+			continue
+		}
+
+		if err := patchPodSpec(&obj, c); err != nil {
+			return errors.Wrapf(err, "patching %q", path)
+		}
+
+		if kind == "Deployment" {
+			setOperatorArgs(&obj, c)
+		}
+
+		patched, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return errors.Wrapf(err, "marshaling patched %q", path)
+		}
+
+		manifests[path] = string(patched)
+	}
+
+	return nil
+}
+
+// patchPodSpec merges c's node affinity and tolerations into obj's pod
+// template spec.
+func patchPodSpec(obj *unstructured.Unstructured, c *component) error {
+	if c.NodeAffinityRaw != "" {
+		var affinity map[string]interface{}
+		if err := yaml.Unmarshal([]byte(c.NodeAffinityRaw), &affinity); err != nil {
+			return errors.Wrap(err, "parsing rendered node affinity")
+		}
+
+		if err := unstructured.SetNestedMap(obj.Object, affinity, "spec", "template", "spec", "affinity"); err != nil {
+			return errors.Wrap(err, "setting pod affinity")
+		}
+	}
+
+	if c.TolerationsRaw != "" {
+		var tolerations []interface{}
+		if err := yaml.Unmarshal([]byte(c.TolerationsRaw), &tolerations); err != nil {
+			return errors.Wrap(err, "parsing rendered tolerations")
+		}
+
+		if err := unstructured.SetNestedSlice(obj.Object, tolerations, "spec", "template", "spec", "tolerations"); err != nil {
+			return errors.Wrap(err, "setting pod tolerations")
+		}
+	}
+
+	return nil
+}
+
+// setOperatorArgs sets the operator container's reboot-window, max-unavailable
+// and node-annotation-selector flags from c's configuration.
+func setOperatorArgs(obj *unstructured.Unstructured, c *component) {
+	containers, ok, _ := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "containers")
+	if !ok || len(containers) == 0 {
+		return
+	}
+
+	container, ok := containers[0].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	args, _, _ := unstructured.NestedStringSlice(container, "args")
+
+	if c.RebootWindow != nil {
+		if c.RebootWindow.Start != "" {
+			args = append(args, fmt.Sprintf("-reboot-window-start=%s", c.RebootWindow.Start))
+		}
+
+		if c.RebootWindow.Length != "" {
+			args = append(args, fmt.Sprintf("-reboot-window-length=%s", c.RebootWindow.Length))
+		}
+	}
+
+	if c.MaxUnavailableNodes != "" {
+		args = append(args, fmt.Sprintf("-max-unavailable=%s", c.MaxUnavailableNodes))
+	}
+
+	if len(c.NodeAnnotationSelector) > 0 {
+		selectors := make([]string, 0, len(c.NodeAnnotationSelector))
+		for k, v := range c.NodeAnnotationSelector {
+			selectors = append(selectors, fmt.Sprintf("%s=%s", k, v))
+		}
+
+		sort.Strings(selectors)
+
+		args = append(args, fmt.Sprintf("-node-annotation-selector=%s", strings.Join(selectors, ",")))
+	}
+
+	container["args"] = args
+	containers[0] = container
+
+	unstructured.SetNestedSlice(obj.Object, containers, "spec", "template", "spec", "containers") //nolint:errcheck
+}
+
+func renderManifest(path string, contents []byte, c *component) (string, error) {
+	tmpl, err := template.New(path).Parse(string(contents))
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, c); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
 func (c *component) Metadata() components.Metadata {
 	return components.Metadata{
 		Namespace: "reboot-coordinator",
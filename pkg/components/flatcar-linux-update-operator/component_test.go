@@ -0,0 +1,150 @@
+package flatcarlinuxupdateoperator
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+const operatorDeployment = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: flatcar-linux-update-operator
+  namespace: reboot-coordinator
+spec:
+  template:
+    spec:
+      containers:
+      - name: update-operator
+        args:
+        - "-analytics=false"
+`
+
+const etcdLockDaemonSet = `
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: flatcar-linux-update-agent-etcd-lock
+  namespace: reboot-coordinator
+spec:
+  template:
+    spec:
+      containers:
+      - name: update-agent
+`
+
+func TestPatchManifestsDropsEtcdLockDaemonSetByDefault(t *testing.T) {
+	manifests := map[string]string{
+		"templates/operator-deployment.yaml": operatorDeployment,
+		"templates/etcd-lock-daemonset.yaml": etcdLockDaemonSet,
+	}
+
+	c := newComponent()
+
+	if err := c.patchManifests(manifests); err != nil {
+		t.Fatalf("patchManifests() returned error: %v", err)
+	}
+
+	if _, ok := manifests["templates/etcd-lock-daemonset.yaml"]; ok {
+		t.Errorf("expected etcd-lock DaemonSet to be dropped when lock_before_reboot is false")
+	}
+}
+
+func TestPatchManifestsKeepsEtcdLockDaemonSetWhenEnabled(t *testing.T) {
+	manifests := map[string]string{
+		"templates/operator-deployment.yaml": operatorDeployment,
+		"templates/etcd-lock-daemonset.yaml": etcdLockDaemonSet,
+	}
+
+	c := newComponent()
+	c.LockBeforeReboot = true
+
+	if err := c.patchManifests(manifests); err != nil {
+		t.Fatalf("patchManifests() returned error: %v", err)
+	}
+
+	if _, ok := manifests["templates/etcd-lock-daemonset.yaml"]; !ok {
+		t.Errorf("expected etcd-lock DaemonSet to be kept when lock_before_reboot is true")
+	}
+}
+
+func TestSetOperatorArgsSortedOrder(t *testing.T) {
+	manifests := map[string]string{
+		"templates/operator-deployment.yaml": operatorDeployment,
+	}
+
+	c := newComponent()
+	c.RebootWindow = &rebootWindow{Start: "Mon 20:00", Length: "2h"}
+	c.MaxUnavailableNodes = "3"
+	c.NodeAnnotationSelector = map[string]string{
+		"zebra": "z",
+		"alpha": "a",
+	}
+
+	if err := c.patchManifests(manifests); err != nil {
+		t.Fatalf("patchManifests() returned error: %v", err)
+	}
+
+	var obj unstructured.Unstructured
+	if err := yaml.Unmarshal([]byte(manifests["templates/operator-deployment.yaml"]), &obj.Object); err != nil {
+		t.Fatalf("unmarshaling patched Deployment: %v", err)
+	}
+
+	containers, _, _ := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "containers")
+	if len(containers) != 1 {
+		t.Fatalf("expected 1 container, got %d", len(containers))
+	}
+
+	container, _ := containers[0].(map[string]interface{})
+
+	args, _, _ := unstructured.NestedStringSlice(container, "args")
+
+	want := []string{
+		"-analytics=false",
+		"-reboot-window-start=Mon 20:00",
+		"-reboot-window-length=2h",
+		"-max-unavailable=3",
+		"-node-annotation-selector=alpha=a,zebra=z",
+	}
+
+	if len(args) != len(want) {
+		t.Fatalf("unexpected args: %v", args)
+	}
+
+	for i, w := range want {
+		if args[i] != w {
+			t.Errorf("arg %d: want %q, got %q (full: %v)", i, w, args[i], args)
+		}
+	}
+}
+
+func TestPatchPodSpecMergesAffinityAndTolerations(t *testing.T) {
+	manifests := map[string]string{
+		"templates/operator-deployment.yaml": operatorDeployment,
+	}
+
+	c := newComponent()
+	c.NodeAffinityRaw = "nodeAffinity:\n  requiredDuringSchedulingIgnoredDuringExecution: {}\n"
+	c.TolerationsRaw = "- key: dedicated\n  operator: Exists\n"
+
+	if err := c.patchManifests(manifests); err != nil {
+		t.Fatalf("patchManifests() returned error: %v", err)
+	}
+
+	var obj unstructured.Unstructured
+	if err := yaml.Unmarshal([]byte(manifests["templates/operator-deployment.yaml"]), &obj.Object); err != nil {
+		t.Fatalf("unmarshaling patched Deployment: %v", err)
+	}
+
+	affinity, ok, _ := unstructured.NestedMap(obj.Object, "spec", "template", "spec", "affinity")
+	if !ok || affinity == nil {
+		t.Errorf("expected pod affinity to be merged in, got: %v", obj.Object)
+	}
+
+	tolerations, ok, _ := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "tolerations")
+	if !ok || len(tolerations) != 1 {
+		t.Errorf("expected 1 toleration to be merged in, got: %v", tolerations)
+	}
+}
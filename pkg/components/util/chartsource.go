@@ -0,0 +1,249 @@
+// Copyright 2020 The Lokomotive Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"oras.land/oras-go/pkg/content"
+	"oras.land/oras-go/pkg/oras"
+)
+
+// chartLayerName is the media layer name lokoctl looks for inside an OCI chart
+// artifact; this matches how Helm itself pushes charts as OCI artifacts.
+const chartLayerName = "chart.tgz"
+
+// ChartSource loads a Helm chart from somewhere: the lokoctl binary's embedded
+// assets, an HTTP chart repository or an OCI registry.
+type ChartSource interface {
+	Load() (*chart.Chart, error)
+}
+
+// AssetsChart loads a chart embedded in the lokoctl binary, which is the default
+// for every component unless a 'chart' block overrides it.
+type AssetsChart struct {
+	Path string
+}
+
+// Load implements ChartSource.
+func (a AssetsChart) Load() (*chart.Chart, error) {
+	return LoadChartFromAssets(a.Path)
+}
+
+// HTTPRepoChart loads a chart from a Helm chart repository's index.yaml.
+type HTTPRepoChart struct {
+	Repository string
+	Name       string
+	Version    string
+	SHA256     string
+}
+
+type chartRepoIndex struct {
+	Entries map[string][]struct {
+		Version string   `yaml:"version"`
+		URLs    []string `yaml:"urls"`
+	} `yaml:"entries"`
+}
+
+// Load implements ChartSource.
+func (h HTTPRepoChart) Load() (*chart.Chart, error) {
+	indexData, err := httpGet(strings.TrimSuffix(h.Repository, "/") + "/index.yaml")
+	if err != nil {
+		return nil, errors.Wrap(err, "fetching chart repository index")
+	}
+
+	var index chartRepoIndex
+	if err := yaml.Unmarshal(indexData, &index); err != nil {
+		return nil, errors.Wrap(err, "parsing chart repository index")
+	}
+
+	entries, ok := index.Entries[h.Name]
+	if !ok {
+		return nil, fmt.Errorf("chart %q not found in repository %q", h.Name, h.Repository)
+	}
+
+	var chartURL string
+
+	for _, entry := range entries {
+		if entry.Version == h.Version {
+			if len(entry.URLs) == 0 {
+				return nil, fmt.Errorf("chart %q version %q has no download URLs", h.Name, h.Version)
+			}
+
+			chartURL = entry.URLs[0]
+
+			break
+		}
+	}
+
+	if chartURL == "" {
+		return nil, fmt.Errorf("version %q of chart %q not found in repository %q", h.Version, h.Name, h.Repository)
+	}
+
+	if !strings.Contains(chartURL, "://") {
+		chartURL = strings.TrimSuffix(h.Repository, "/") + "/" + chartURL
+	}
+
+	data, err := httpGet(chartURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "downloading chart from %q", chartURL)
+	}
+
+	if err := verifySHA256(data, h.SHA256); err != nil {
+		return nil, errors.Wrapf(err, "verifying chart %q version %q", h.Name, h.Version)
+	}
+
+	return loader.LoadArchive(bytes.NewReader(data))
+}
+
+// OCIChart loads a chart pushed as an OCI artifact, e.g. oci://registry/chart:tag.
+type OCIChart struct {
+	Ref    string
+	SHA256 string
+}
+
+// Load implements ChartSource.
+func (o OCIChart) Load() (*chart.Chart, error) {
+	store := content.NewMemoryStore()
+	resolver := docker.NewResolver(docker.ResolverOptions{})
+
+	_, _, err := oras.Pull(context.Background(), resolver, strings.TrimPrefix(o.Ref, "oci://"), store)
+	if err != nil {
+		return nil, errors.Wrapf(err, "pulling OCI chart %q", o.Ref)
+	}
+
+	_, data, ok := store.GetByName(chartLayerName)
+	if !ok {
+		return nil, fmt.Errorf("OCI artifact %q does not contain a chart layer", o.Ref)
+	}
+
+	if err := verifySHA256(data, o.SHA256); err != nil {
+		return nil, errors.Wrapf(err, "verifying chart %q", o.Ref)
+	}
+
+	return loader.LoadArchive(bytes.NewReader(data))
+}
+
+// ChartConfig is the optional 'chart' HCL block every component accepts to pin a
+// chart other than the one embedded in the lokoctl binary.
+type ChartConfig struct {
+	Repository string `hcl:"repository,optional"`
+	Name       string `hcl:"name,optional"`
+	Version    string `hcl:"version,optional"`
+	SHA256     string `hcl:"sha256,optional"`
+}
+
+// Source returns the ChartSource described by c, falling back to the chart
+// embedded at assetsPath when c is nil or empty.
+func (c *ChartConfig) Source(assetsPath string) ChartSource {
+	if c == nil || c.Repository == "" {
+		return AssetsChart{Path: assetsPath}
+	}
+
+	if strings.HasPrefix(c.Repository, "oci://") {
+		return OCIChart{
+			Ref:    fmt.Sprintf("%s/%s:%s", strings.TrimSuffix(c.Repository, "/"), c.Name, c.Version),
+			SHA256: c.SHA256,
+		}
+	}
+
+	return HTTPRepoChart{
+		Repository: c.Repository,
+		Name:       c.Name,
+		Version:    c.Version,
+		SHA256:     c.SHA256,
+	}
+}
+
+// ValidateChart checks a component's optional 'chart' block for the fields
+// Source needs to resolve it to a ChartSource, so a misconfigured pin (e.g. a
+// 'repository' with no 'name'/'version', or no 'sha256' to verify the
+// download against) is reported as a config diagnostic instead of failing
+// deep inside RenderManifests with an opaque "chart not found" or checksum
+// error. A nil chart, or one with no 'repository' set, falls back to the
+// chart embedded in the lokoctl binary and needs no validation.
+func ValidateChart(chart *ChartConfig, diagnostics hcl.Diagnostics) hcl.Diagnostics {
+	if chart == nil || chart.Repository == "" {
+		return diagnostics
+	}
+
+	if chart.Name == "" {
+		diagnostics = append(diagnostics, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "'name' must be set",
+			Detail:   "'chart' block sets 'repository' but not 'name'",
+		})
+	}
+
+	if chart.Version == "" {
+		diagnostics = append(diagnostics, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "'version' must be set",
+			Detail:   "'chart' block sets 'repository' but not 'version'",
+		})
+	}
+
+	if chart.SHA256 == "" {
+		diagnostics = append(diagnostics, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "'sha256' must be set",
+			Detail:   "'chart' block must set 'sha256' to verify the pinned chart's download",
+		})
+	}
+
+	return diagnostics
+}
+
+func verifySHA256(data []byte, want string) error {
+	if want == "" {
+		return fmt.Errorf("'sha256' must be set in the 'chart' block to pin a non-embedded chart")
+	}
+
+	sum := sha256.Sum256(data)
+	got := hex.EncodeToString(sum[:])
+
+	if got != want {
+		return fmt.Errorf("checksum mismatch: expected %q, got %q", want, got)
+	}
+
+	return nil
+}
+
+func httpGet(url string) ([]byte, error) {
+	resp, err := http.Get(url) //nolint:gosec,noctx
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %q fetching %q", resp.Status, url)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,125 @@
+// Copyright 2020 The Lokomotive Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+func TestChartConfigSourceNilFallsBackToAssets(t *testing.T) {
+	var c *ChartConfig
+
+	src, ok := c.Source("/components/example").(AssetsChart)
+	if !ok {
+		t.Fatalf("expected a nil chart to fall back to AssetsChart, got %T", c.Source("/components/example"))
+	}
+
+	if src.Path != "/components/example" {
+		t.Errorf("expected assetsPath to be used, got %q", src.Path)
+	}
+}
+
+func TestChartConfigSourceEmptyRepositoryFallsBackToAssets(t *testing.T) {
+	c := &ChartConfig{}
+
+	if _, ok := c.Source("/components/example").(AssetsChart); !ok {
+		t.Fatalf("expected an empty 'repository' to fall back to AssetsChart, got %T", c.Source("/components/example"))
+	}
+}
+
+func TestChartConfigSourceOCI(t *testing.T) {
+	c := &ChartConfig{
+		Repository: "oci://registry.example.com/charts",
+		Name:       "example",
+		Version:    "1.2.3",
+		SHA256:     "deadbeef",
+	}
+
+	src, ok := c.Source("/components/example").(OCIChart)
+	if !ok {
+		t.Fatalf("expected an 'oci://' repository to produce an OCIChart, got %T", c.Source("/components/example"))
+	}
+
+	if src.Ref != "oci://registry.example.com/charts/example:1.2.3" {
+		t.Errorf("unexpected OCI ref: %q", src.Ref)
+	}
+
+	if src.SHA256 != "deadbeef" {
+		t.Errorf("expected SHA256 to be passed through, got %q", src.SHA256)
+	}
+}
+
+func TestChartConfigSourceHTTPRepo(t *testing.T) {
+	c := &ChartConfig{
+		Repository: "https://charts.example.com",
+		Name:       "example",
+		Version:    "1.2.3",
+		SHA256:     "deadbeef",
+	}
+
+	src, ok := c.Source("/components/example").(HTTPRepoChart)
+	if !ok {
+		t.Fatalf("expected a non-OCI repository to produce an HTTPRepoChart, got %T", c.Source("/components/example"))
+	}
+
+	if src.Repository != c.Repository || src.Name != c.Name || src.Version != c.Version || src.SHA256 != c.SHA256 {
+		t.Errorf("fields weren't passed through unchanged: %+v", src)
+	}
+}
+
+func TestVerifySHA256(t *testing.T) {
+	data := []byte("chart contents")
+	// sha256("chart contents")
+	const sum = "48d492eade212236b0c6bb101caaab594b0b6721b14afe1d0df72182738ab8e6"
+
+	if err := verifySHA256(data, sum); err != nil {
+		t.Errorf("verifySHA256() with a matching checksum returned error: %v", err)
+	}
+
+	if err := verifySHA256(data, "wrong"); err == nil {
+		t.Errorf("expected verifySHA256() to fail on a checksum mismatch")
+	}
+
+	if err := verifySHA256(data, ""); err == nil {
+		t.Errorf("expected verifySHA256() to fail when no checksum is set")
+	}
+}
+
+func TestValidateChartNoop(t *testing.T) {
+	for name, chart := range map[string]*ChartConfig{
+		"nil chart":            nil,
+		"no repository":        {},
+		"fully configured":     {Repository: "https://charts.example.com", Name: "example", Version: "1.0.0", SHA256: "deadbeef"},
+		"oci fully configured": {Repository: "oci://registry.example.com/charts", Name: "example", Version: "1.0.0", SHA256: "deadbeef"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if diagnostics := ValidateChart(chart, hcl.Diagnostics{}); diagnostics.HasErrors() {
+				t.Errorf("expected no diagnostics, got: %s", diagnostics.Error())
+			}
+		})
+	}
+}
+
+func TestValidateChartMissingFields(t *testing.T) {
+	chart := &ChartConfig{Repository: "https://charts.example.com"}
+
+	diagnostics := ValidateChart(chart, hcl.Diagnostics{})
+
+	if len(diagnostics) != 3 {
+		t.Fatalf("expected 3 diagnostics (missing name, version, sha256), got %d: %s", len(diagnostics), diagnostics.Error())
+	}
+}
@@ -0,0 +1,382 @@
+// Copyright 2020 The Lokomotive Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package varnish deploys an HTTP edge cache in front of Contour's Envoy
+// service, plus a sidecar controller that watches Ingress/HTTPProxy resources
+// and reloads the cache's VCL configuration when they change.
+package varnish
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+
+	"github.com/kinvolk/lokomotive/internal"
+	internaltemplate "github.com/kinvolk/lokomotive/internal/template"
+	"github.com/kinvolk/lokomotive/pkg/components"
+	"github.com/kinvolk/lokomotive/pkg/components/util"
+)
+
+const name = "varnish"
+
+const chartValuesTmpl = `
+cacheSize: {{ .CacheSize }}
+defaultTTL: {{ .DefaultTTL }}
+backendSelector:
+{{ range $k, $v := .BackendSelector }}
+  {{ $k }}: {{ $v }}
+{{ end }}
+
+{{ if .HostOverrides }}
+hostOverrides:
+{{ range .HostOverrides }}
+- host: {{ .Host }}
+  ttl: {{ .TTL }}
+{{ end }}
+{{ end }}
+
+controller:
+  reloadDebounce: {{ .ReloadDebounce }}
+
+nodeAffinity:
+{{ .NodeAffinityRaw }}
+tolerations:
+{{ .TolerationsRaw }}
+
+{{ if .EnableMonitoring }}
+serviceMonitor:
+  enabled: true
+{{ end }}
+`
+
+// configMapTmpl, daemonSetTmpl, serviceTmpl and serviceMonitorTmpl render the
+// component's default manifests directly, without going through Helm. Unlike
+// the upstream charts cluster-autoscaler and contour wrap, varnish is
+// Lokomotive-native: there's no third-party chart to embed, so the 'chart'
+// block stays available for operators who want to bring their own, but isn't
+// required to render anything.
+const configMapTmpl = `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: varnish-vcl
+  namespace: {{ .Namespace }}
+data:
+  default.vcl: |
+    vcl 4.1;
+    backend default {
+      .host = "envoy";
+      .port = "8080";
+    }
+`
+
+const daemonSetTmpl = `
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: varnish
+  namespace: {{ .Namespace }}
+  labels:
+    app: varnish
+spec:
+  selector:
+    matchLabels:
+      app: varnish
+  template:
+    metadata:
+      labels:
+        app: varnish
+    spec:
+      affinity:
+{{ .NodeAffinityRaw }}
+      tolerations:
+{{ .TolerationsRaw }}
+      volumes:
+      - name: vcl-live
+        emptyDir: {}
+      - name: vcl-seed
+        configMap:
+          name: varnish-vcl
+      - name: reload-socket
+        emptyDir: {}
+      - name: varnish-workdir
+        emptyDir: {}
+      initContainers:
+      - name: seed-vcl
+        # vcl-live starts out as an empty emptyDir, and the varnish-controller
+        # sidecar only writes a real VCL once it's reconciled at least once
+        # (debounced by --reload-debounce). Without this, the varnish
+        # container would crash-loop on every fresh pod (new node, rollout,
+        # reschedule) until that first reconcile lands. Seed it from the
+        # varnish-vcl ConfigMap instead, so varnishd always has something
+        # valid to load immediately.
+        image: busybox:1.32
+        command: ["cp", "/seed/default.vcl", "/etc/varnish/default.vcl"]
+        volumeMounts:
+        - name: vcl-seed
+          mountPath: /seed
+        - name: vcl-live
+          mountPath: /etc/varnish
+      containers:
+      - name: varnish
+        image: varnish:6.5
+        args:
+        - "-F"
+        - "-f"
+        - "/etc/varnish/default.vcl"
+        - "-s"
+        - "malloc,{{ .CacheSize }}"
+        - "-a"
+        - ":80"
+        - "-n"
+        - "/var/lib/varnish"
+        ports:
+        - containerPort: 80
+        volumeMounts:
+        - name: vcl-live
+          mountPath: /etc/varnish
+        - name: varnish-workdir
+          mountPath: /var/lib/varnish
+      - name: varnish-controller
+        # Sidecar implementing pkg/components/varnish/controller: it watches
+        # Ingress/HTTPProxy, regenerates VCL, validates it with 'varnishd -C'
+        # and only then reloads the varnish container above. --workdir must
+        # match the varnish container's '-n' flag: that's where varnishd
+        # drops the CLI secret file and VSM segment varnishadm needs to
+        # attach to the running instance, and it's only visible here because
+        # the varnish-workdir volume is mounted into both containers.
+        image: lokomotive/varnish-controller:latest
+        args:
+        - "watch"
+        - "--vcl-path=/etc/varnish/default.vcl"
+        - "--socket-path=/run/varnish-controller/reload.sock"
+        - "--reload-debounce={{ .ReloadDebounce }}"
+        - "--default-ttl={{ .DefaultTTL }}"
+        - "--workdir=/var/lib/varnish"
+{{ range $k, $v := .BackendSelector }}
+        - "--backend-selector={{ $k }}={{ $v }}"
+{{ end }}
+{{ range .HostOverrides }}
+        - "--host-ttl-override={{ .Host }}={{ .TTL }}"
+{{ end }}
+        env:
+        - name: POD_NAMESPACE
+          valueFrom:
+            fieldRef:
+              fieldPath: metadata.namespace
+        volumeMounts:
+        - name: vcl-live
+          mountPath: /etc/varnish
+        - name: reload-socket
+          mountPath: /run/varnish-controller
+        - name: varnish-workdir
+          mountPath: /var/lib/varnish
+`
+
+const serviceTmpl = `
+apiVersion: v1
+kind: Service
+metadata:
+  name: varnish
+  namespace: {{ .Namespace }}
+spec:
+  selector:
+    app: varnish
+  ports:
+  - port: 80
+    targetPort: 80
+`
+
+const serviceMonitorTmpl = `
+apiVersion: monitoring.coreos.com/v1
+kind: ServiceMonitor
+metadata:
+  name: varnish
+  namespace: {{ .Namespace }}
+spec:
+  selector:
+    matchLabels:
+      app: varnish
+  endpoints:
+  - port: metrics
+`
+
+func init() {
+	components.Register(name, newComponent())
+}
+
+type component struct {
+	CacheSize       string            `hcl:"cache_size,optional"`
+	DefaultTTL      string            `hcl:"default_ttl,optional"`
+	BackendSelector map[string]string `hcl:"backend_selector,optional"`
+	// ReloadDebounce bounds how often the sidecar controller is allowed to
+	// regenerate and reload VCL in response to Ingress/HTTPProxy churn.
+	ReloadDebounce string `hcl:"reload_debounce,optional"`
+
+	// HostOverrides forces a TTL for specific hosts, taking precedence over
+	// both DefaultTTL and any varnish.lokomotive.io/ttl annotation on the
+	// matching Ingress/HTTPProxy. It's wired into both the default,
+	// chart-free manifest path (as --host-ttl-override flags on the sidecar
+	// controller, see daemonSetTmpl) and chartValuesTmpl for a user-supplied
+	// chart.
+	HostOverrides []hostOverride `hcl:"host_override,block"`
+
+	NodeAffinity    []util.NodeAffinity `hcl:"node_affinity,block"`
+	NodeAffinityRaw string
+	Tolerations     []util.Toleration `hcl:"toleration,block"`
+	TolerationsRaw  string
+
+	EnableMonitoring bool `hcl:"enable_monitoring,optional"`
+
+	// Chart overrides the component's default, directly-rendered manifests
+	// with a user-supplied Helm chart.
+	Chart *util.ChartConfig `hcl:"chart,block"`
+
+	// Namespace is set from Metadata().Namespace before rendering, so the
+	// default manifest templates can reference it without a method call.
+	Namespace string
+}
+
+type hostOverride struct {
+	Host string `hcl:"host,label"`
+	TTL  string `hcl:"ttl,optional"`
+}
+
+func newComponent() *component {
+	return &component{
+		CacheSize:       "1G",
+		DefaultTTL:      "120s",
+		ReloadDebounce:  "5s",
+		BackendSelector: map[string]string{"app": "envoy"},
+	}
+}
+
+func (c *component) LoadConfig(configBody *hcl.Body, evalContext *hcl.EvalContext) hcl.Diagnostics {
+	diagnostics := hcl.Diagnostics{}
+
+	if configBody == nil {
+		return diagnostics
+	}
+
+	d := gohcl.DecodeBody(*configBody, evalContext, c)
+	if d.HasErrors() {
+		diagnostics = append(diagnostics, d...)
+		return diagnostics
+	}
+
+	if len(c.BackendSelector) == 0 {
+		diagnostics = append(diagnostics, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "'backend_selector' must be set",
+			Detail:   "'backend_selector' must select at least one label on the Envoy pods",
+		})
+	}
+
+	for _, o := range c.HostOverrides {
+		if o.TTL == "" {
+			diagnostics = append(diagnostics, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "'host_override' must set 'ttl'",
+				Detail:   fmt.Sprintf("the 'host_override' block for %q doesn't set 'ttl'", o.Host),
+			})
+		}
+	}
+
+	diagnostics = util.ValidateChart(c.Chart, diagnostics)
+
+	return diagnostics
+}
+
+func (c *component) RenderManifests() (map[string]string, error) {
+	var err error
+
+	c.Namespace = c.Metadata().Namespace
+
+	c.TolerationsRaw, err = util.RenderTolerations(c.Tolerations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal tolerations: %w", err)
+	}
+
+	c.NodeAffinityRaw, err = util.RenderNodeAffinity(c.NodeAffinity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal node affinity: %w", err)
+	}
+
+	// Only go through Helm if the operator explicitly pinned a chart to use
+	// instead of the component's own manifests.
+	if c.Chart == nil || c.Chart.Repository == "" {
+		return c.renderDefaultManifests()
+	}
+
+	helmChart, err := c.Chart.Source(fmt.Sprintf("/components/%s", name)).Load()
+	if err != nil {
+		return nil, fmt.Errorf("load chart: %w", err)
+	}
+
+	values, err := internaltemplate.Render(chartValuesTmpl, c)
+	if err != nil {
+		return nil, fmt.Errorf("rendering values template failed: %w", err)
+	}
+
+	renderedFiles, err := util.RenderChart(helmChart, name, c.Metadata().Namespace, values)
+	if err != nil {
+		return nil, fmt.Errorf("rendering chart failed: %w", err)
+	}
+
+	return renderedFiles, nil
+}
+
+// renderDefaultManifests renders the ConfigMap, DaemonSet and Service that
+// make up the component by default, without requiring a chart to be embedded
+// in the lokoctl binary or pinned via a 'chart' block.
+func (c *component) renderDefaultManifests() (map[string]string, error) {
+	templates := map[string]string{
+		"configmap.yaml": configMapTmpl,
+		"daemonset.yaml": daemonSetTmpl,
+		"service.yaml":   serviceTmpl,
+	}
+
+	if c.EnableMonitoring {
+		templates["servicemonitor.yaml"] = serviceMonitorTmpl
+	}
+
+	rendered := make(map[string]string, len(templates))
+
+	for path, tmpl := range templates {
+		out, err := internaltemplate.Render(tmpl, c)
+		if err != nil {
+			return nil, fmt.Errorf("rendering %q: %w", path, err)
+		}
+
+		rendered[path] = out
+	}
+
+	return rendered, nil
+}
+
+func (c *component) Metadata() components.Metadata {
+	return components.Metadata{
+		Name:      name,
+		Namespace: "projectcontour",
+	}
+}
+
+// GetNamespaceLabels returns labels that should be added to the component namespace.
+func (c *component) GetNamespaceLabels() map[string]string {
+	labels := map[string]string{}
+	internal.AppendNamespaceNameToLabels(c.Metadata().Namespace, &labels)
+
+	return labels
+}
@@ -0,0 +1,418 @@
+// Copyright 2020 The Lokomotive Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package controller implements the varnish component's sidecar: it watches
+// Ingress and HTTPProxy objects, regenerates the VCL configuration they
+// describe, validates it with `varnishd -C` before it ever touches the live
+// config, and only reloads the running varnishd once a debounced, validated
+// VCL is ready. A failed validation leaves the previously loaded VCL in
+// place. It is meant to run as the `varnish-controller` container in the
+// component's DaemonSet (see ../component.go's daemonSetTmpl), and it also
+// listens on a Unix socket for out-of-band reload requests: connecting to it
+// (e.g. `kubectl exec <pod> -c varnish-controller -- sh -c 'echo | nc -U
+// /run/varnish-controller/reload.sock'`) requests a reconciliation, which
+// runs after the same debounce as one triggered by an Ingress/HTTPProxy change.
+package controller
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+var (
+	ingressGVR   = schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"}
+	httpProxyGVR = schema.GroupVersionResource{Group: "projectcontour.io", Version: "v1", Resource: "httpproxies"}
+	podGVR       = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+)
+
+// Config configures a Controller.
+type Config struct {
+	// VCLPath is where the validated VCL is written once it's safe to load.
+	VCLPath string
+	// SocketPath is the Unix socket Controller listens on for out-of-band
+	// reload requests, e.g. triggered via `kubectl exec`.
+	SocketPath string
+	// ReloadDebounce bounds how often Ingress/HTTPProxy churn is allowed to
+	// trigger a VCL regeneration and reload.
+	ReloadDebounce time.Duration
+	// DefaultTTL is used for any host that doesn't request its own via the
+	// varnish.lokomotive.io/ttl annotation.
+	DefaultTTL string
+	// HostTTLOverrides forces a TTL for specific hosts, taking precedence over
+	// both DefaultTTL and any varnish.lokomotive.io/ttl annotation the
+	// matching Ingress/HTTPProxy sets: it's how the component's HCL
+	// 'host_override' blocks (see ../component.go) reach the default,
+	// chart-free manifest path.
+	HostTTLOverrides map[string]string
+	// BackendSelector picks the Envoy pods VCL backends are generated for.
+	// Only pods in Namespace are considered.
+	BackendSelector map[string]string
+	// Namespace is the namespace BackendSelector is evaluated in; normally the
+	// sidecar's own namespace (see $POD_NAMESPACE in component.go's
+	// daemonSetTmpl), since that's where Envoy runs too.
+	Namespace string
+	// WorkDir is the varnishd instance workdir (its '-n' flag) shared with the
+	// varnish container via a volume mount: it's where varnishd drops the CLI
+	// secret file and VSM segment that varnishadm needs to reach the running
+	// instance from this sidecar's filesystem.
+	WorkDir string
+}
+
+// Controller watches Ingress and HTTPProxy objects and keeps a local
+// varnishd's VCL configuration in sync with them.
+type Controller struct {
+	client  dynamic.Interface
+	config  Config
+	pending chan struct{}
+}
+
+// New returns a Controller that reconciles VCL for the objects visible to client.
+func New(client dynamic.Interface, config Config) *Controller {
+	return &Controller{
+		client: client,
+		config: config,
+		// Buffered by one: a pending reconcile already covers any reload
+		// request that arrives while one is queued.
+		pending: make(chan struct{}, 1),
+	}
+}
+
+// Run watches Ingress/HTTPProxy objects and the Envoy pods matching
+// BackendSelector, and serves the reload socket, until ctx is canceled. It
+// blocks until then.
+func (c *Controller) Run(ctx context.Context) error {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(c.client, 30*time.Second)
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { c.requestReconcile() },
+		UpdateFunc: func(interface{}, interface{}) { c.requestReconcile() },
+		DeleteFunc: func(interface{}) { c.requestReconcile() },
+	}
+
+	ingresses := factory.ForResource(ingressGVR).Informer()
+	ingresses.AddEventHandler(handler)
+
+	httpProxies := factory.ForResource(httpProxyGVR).Informer()
+	httpProxies.AddEventHandler(handler)
+
+	// Pods need their own factory: the backend selector only applies to them,
+	// and dynamicinformer applies one set of list options to every resource a
+	// factory serves.
+	podSelector := labels.SelectorFromSet(c.config.BackendSelector).String()
+	podFactory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.client, 30*time.Second, c.config.Namespace,
+		func(options *metav1.ListOptions) { options.LabelSelector = podSelector })
+
+	pods := podFactory.ForResource(podGVR).Informer()
+	pods.AddEventHandler(handler)
+
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+
+	podFactory.Start(ctx.Done())
+	podFactory.WaitForCacheSync(ctx.Done())
+
+	listener, err := c.listen(ctx)
+	if err != nil {
+		return errors.Wrap(err, "starting reload socket")
+	}
+	defer listener.Close()
+
+	go c.serveReloadRequests(ctx, listener)
+
+	// Reconcile once at startup so the sidecar doesn't serve an empty VCL
+	// until the first Ingress/HTTPProxy change comes in.
+	c.requestReconcile()
+
+	debounce := c.config.ReloadDebounce
+	if debounce <= 0 {
+		debounce = 5 * time.Second
+	}
+
+	var debounceTimer *time.Timer
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-c.pending:
+			if debounceTimer != nil {
+				debounceTimer.Stop()
+			}
+
+			debounceTimer = time.AfterFunc(debounce, func() {
+				if err := c.reconcile(ingresses, httpProxies, pods); err != nil {
+					// Keep serving the last known-good VCL; log and wait for the
+					// next change or manual reload rather than crashing the sidecar.
+					log.Printf("varnish-controller: reconcile failed, keeping previous VCL: %v", err)
+				}
+			})
+		}
+	}
+}
+
+func (c *Controller) requestReconcile() {
+	select {
+	case c.pending <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Controller) listen(ctx context.Context) (net.Listener, error) {
+	_ = os.Remove(c.config.SocketPath)
+
+	var lc net.ListenConfig
+
+	return lc.Listen(ctx, "unix", c.config.SocketPath)
+}
+
+func (c *Controller) serveReloadRequests(ctx context.Context, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			log.Printf("varnish-controller: accepting reload request: %v", err)
+
+			continue
+		}
+
+		conn.Close()
+		c.requestReconcile()
+	}
+}
+
+// reconcile lists the current Ingress/HTTPProxy rules and Envoy backend pods,
+// renders VCL for them, validates it with `varnishd -C` and, only if that
+// succeeds, writes it to VCLPath and tells the running varnishd to reload it.
+func (c *Controller) reconcile(ingresses, httpProxies, pods cache.SharedIndexInformer) error {
+	rules := rulesFromStore(ingresses.GetStore())
+	rules = append(rules, rulesFromStore(httpProxies.GetStore())...)
+
+	// Appended last so they win over any annotation-derived TTL for the same
+	// host: renderVCL keeps the last rule it sees per host.
+	for host, ttl := range c.config.HostTTLOverrides {
+		rules = append(rules, rule{Host: host, TTL: ttl})
+	}
+
+	// An empty backend set (e.g. at startup, before any Envoy pod has gone
+	// Ready) isn't treated as a reconcile failure: renderVCL still produces
+	// valid VCL for it, one that fails requests with a 503 instead of
+	// leaving the sidecar with no VCL loaded at all until backends appear.
+	backends := backendsFromStore(pods.GetStore())
+	if len(backends) == 0 {
+		log.Printf("varnish-controller: no pods match backend selector %v in namespace %q, serving 503s", c.config.BackendSelector, c.config.Namespace)
+	}
+
+	vcl, err := renderVCL(rules, backends, c.config.DefaultTTL)
+	if err != nil {
+		return errors.Wrap(err, "rendering VCL")
+	}
+
+	if err := validateVCL(vcl); err != nil {
+		return errors.Wrap(err, "validating VCL")
+	}
+
+	if err := ioutil.WriteFile(c.config.VCLPath, []byte(vcl), 0o644); err != nil {
+		return errors.Wrap(err, "writing VCL")
+	}
+
+	return reloadVarnish(c.config.VCLPath, c.config.WorkDir)
+}
+
+// validateVCL asks varnishd to compile vcl without loading it, so a bad
+// Ingress/HTTPProxy never takes down the cache that's already serving traffic.
+func validateVCL(vcl string) error {
+	tmp, err := ioutil.TempFile("", "varnish-*.vcl")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary VCL file")
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := tmp.WriteString(vcl); err != nil {
+		return errors.Wrap(err, "writing temporary VCL file")
+	}
+
+	out, err := exec.Command("varnishd", "-C", "-f", tmp.Name()).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("varnishd -C: %w: %s", err, out)
+	}
+
+	return nil
+}
+
+// reloadVarnish asks the running varnishd to load and switch to the new VCL
+// without dropping any connections. workDir must match the '-n' the varnish
+// container started varnishd with, since that's where varnishadm finds the
+// CLI secret file and VSM segment to authenticate to and address that
+// instance from this sidecar's own filesystem.
+func reloadVarnish(vclPath, workDir string) error {
+	// vcl.load requires a unique label each time, since varnishd keeps
+	// previously loaded (but unused) VCLs around until explicitly discarded.
+	vclName := fmt.Sprintf("lokomotive-%d", time.Now().UnixNano())
+
+	if out, err := exec.Command("varnishadm", "-n", workDir, "vcl.load", vclName, vclPath).CombinedOutput(); err != nil {
+		return fmt.Errorf("varnishadm vcl.load: %w: %s", err, out)
+	}
+
+	if out, err := exec.Command("varnishadm", "-n", workDir, "vcl.use", vclName).CombinedOutput(); err != nil {
+		return fmt.Errorf("varnishadm vcl.use: %w: %s", err, out)
+	}
+
+	return nil
+}
+
+type rule struct {
+	Host string
+	TTL  string
+}
+
+// backend is an Envoy pod VCL should load-balance across, as discovered via
+// Config.BackendSelector.
+type backend struct {
+	// Name is a VCL identifier derived from the pod's index in the sorted
+	// backend list, since VCL backend names can't contain dots and a pod IP
+	// isn't a valid identifier.
+	Name string
+	IP   string
+}
+
+// podReady reports whether u's Ready condition is True, the same check a
+// Kubernetes Service uses to decide whether to route traffic to a pod.
+func podReady(u *unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(u.Object, "status", "conditions")
+
+	for _, c := range conditions {
+		m, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if m["type"] == "Ready" {
+			return m["status"] == "True"
+		}
+	}
+
+	return false
+}
+
+// backendsFromStore returns a backend for every Running, Ready pod in store
+// with a pod IP assigned, sorted by pod name so the generated VCL is stable
+// across reconciles that don't actually change the backend set.
+func backendsFromStore(store cache.Store) []backend {
+	var pods []*unstructured.Unstructured
+
+	for _, obj := range store.List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+
+		phase, _, _ := unstructured.NestedString(u.Object, "status", "phase")
+		if phase != "Running" {
+			continue
+		}
+
+		ip, found, _ := unstructured.NestedString(u.Object, "status", "podIP")
+		if !found || ip == "" {
+			continue
+		}
+
+		if !podReady(u) {
+			continue
+		}
+
+		pods = append(pods, u)
+	}
+
+	sort.Slice(pods, func(i, j int) bool { return pods[i].GetName() < pods[j].GetName() })
+
+	backends := make([]backend, 0, len(pods))
+
+	for i, p := range pods {
+		ip, _, _ := unstructured.NestedString(p.Object, "status", "podIP")
+		backends = append(backends, backend{Name: fmt.Sprintf("envoy%d", i), IP: ip})
+	}
+
+	return backends
+}
+
+func rulesFromStore(store cache.Store) []rule {
+	var rules []rule
+
+	for _, obj := range store.List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+
+		rules = append(rules, rulesFromObject(u)...)
+	}
+
+	return rules
+}
+
+func rulesFromObject(u *unstructured.Unstructured) []rule {
+	ttl, _, _ := unstructured.NestedString(u.Object, "metadata", "annotations", "varnish.lokomotive.io/ttl")
+
+	switch u.GetKind() {
+	case "HTTPProxy":
+		host, found, _ := unstructured.NestedString(u.Object, "spec", "virtualhost", "fqdn")
+		if !found || host == "" {
+			return nil
+		}
+
+		return []rule{{Host: host, TTL: ttl}}
+	case "Ingress":
+		hosts, _, _ := unstructured.NestedSlice(u.Object, "spec", "rules")
+
+		var rules []rule
+
+		for _, h := range hosts {
+			m, ok := h.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			host, _ := m["host"].(string)
+			if host == "" {
+				continue
+			}
+
+			rules = append(rules, rule{Host: host, TTL: ttl})
+		}
+
+		return rules
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+)
+
+func podObject(name, phase, ip string, ready bool) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "projectcontour"},
+		"status": map[string]interface{}{
+			"phase": phase,
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Ready", "status": readyStatus(ready)},
+			},
+		},
+	}}
+
+	if ip != "" {
+		_ = unstructured.SetNestedField(obj.Object, ip, "status", "podIP")
+	}
+
+	return obj
+}
+
+func readyStatus(ready bool) string {
+	if ready {
+		return "True"
+	}
+
+	return "False"
+}
+
+func TestBackendsFromStore(t *testing.T) {
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	_ = store.Add(podObject("envoy-b", "Running", "10.0.0.2", true))
+	_ = store.Add(podObject("envoy-a", "Running", "10.0.0.1", true))
+	_ = store.Add(podObject("envoy-pending", "Pending", "", false))
+	_ = store.Add(podObject("envoy-no-ip", "Running", "", true))
+	_ = store.Add(podObject("envoy-not-ready", "Running", "10.0.0.3", false))
+
+	backends := backendsFromStore(store)
+
+	if len(backends) != 2 {
+		t.Fatalf("expected 2 backends, got %d: %+v", len(backends), backends)
+	}
+
+	// Sorted by pod name (envoy-a before envoy-b), so envoy0 is 10.0.0.1.
+	if backends[0].IP != "10.0.0.1" || backends[1].IP != "10.0.0.2" {
+		t.Errorf("unexpected backend order: %+v", backends)
+	}
+
+	if backends[0].Name != "envoy0" || backends[1].Name != "envoy1" {
+		t.Errorf("unexpected backend names: %+v", backends)
+	}
+}
+
+func TestBackendsFromStoreEmpty(t *testing.T) {
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+
+	if backends := backendsFromStore(store); len(backends) != 0 {
+		t.Fatalf("expected no backends, got %+v", backends)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 The Lokomotive Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+const vclTmpl = `
+vcl 4.1;
+import directors;
+
+{{ range .Backends }}
+backend {{ .Name }} {
+  .host = "{{ .IP }}";
+  .port = "8080";
+}
+{{ end }}
+
+{{ if .Backends }}
+sub vcl_init {
+  new envoy = directors.round_robin();
+{{ range .Backends }}
+  envoy.add_backend({{ .Name }});
+{{ end }}
+}
+
+sub vcl_recv {
+  set req.backend_hint = envoy.backend();
+}
+{{ else }}
+# No Envoy backend currently matches backend_selector (e.g. the DaemonSet is
+# still rolling out). Fail requests explicitly rather than loading no VCL at
+# all, so varnish keeps this config until backends reappear.
+sub vcl_recv {
+  return (synth(503, "no Envoy backends available"));
+}
+{{ end }}
+
+sub vcl_backend_response {
+{{ range .Hosts }}
+  if (bereq.http.host == "{{ .Host }}") {
+    set beresp.ttl = {{ .TTL }};
+  }
+{{ end }}
+}
+`
+
+type vclHost struct {
+	Host string
+	TTL  string
+}
+
+// renderVCL generates the VCL configuration for rules and backends, giving
+// every host its own TTL override (falling back to defaultTTL) and
+// round-robin balancing requests across backends. Hosts are sorted so the
+// output is stable across reconciles that don't actually change anything;
+// backends are already sorted by backendsFromStore.
+func renderVCL(rules []rule, backends []backend, defaultTTL string) (string, error) {
+	byHost := make(map[string]string, len(rules))
+
+	for _, r := range rules {
+		ttl := r.TTL
+		if ttl == "" {
+			ttl = defaultTTL
+		}
+
+		byHost[r.Host] = ttl
+	}
+
+	hosts := make([]vclHost, 0, len(byHost))
+	for host, ttl := range byHost {
+		hosts = append(hosts, vclHost{Host: host, TTL: ttl})
+	}
+
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Host < hosts[j].Host })
+
+	tmpl, err := template.New("vcl").Parse(vclTmpl)
+	if err != nil {
+		return "", errors.Wrap(err, "parsing VCL template")
+	}
+
+	data := struct {
+		Hosts    []vclHost
+		Backends []backend
+	}{Hosts: hosts, Backends: backends}
+
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", errors.Wrap(err, "executing VCL template")
+	}
+
+	return buf.String(), nil
+}
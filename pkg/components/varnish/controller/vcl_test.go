@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderVCLBackends(t *testing.T) {
+	backends := []backend{
+		{Name: "envoy0", IP: "10.0.0.1"},
+		{Name: "envoy1", IP: "10.0.0.2"},
+	}
+
+	vcl, err := renderVCL(nil, backends, "60s")
+	if err != nil {
+		t.Fatalf("renderVCL() returned error: %v", err)
+	}
+
+	for _, want := range []string{
+		`backend envoy0 {`,
+		`.host = "10.0.0.1";`,
+		`backend envoy1 {`,
+		`.host = "10.0.0.2";`,
+		`envoy.add_backend(envoy0);`,
+		`envoy.add_backend(envoy1);`,
+	} {
+		if !strings.Contains(vcl, want) {
+			t.Errorf("rendered VCL missing %q:\n%s", want, vcl)
+		}
+	}
+}
+
+func TestRenderVCLNoBackends(t *testing.T) {
+	vcl, err := renderVCL(nil, nil, "60s")
+	if err != nil {
+		t.Fatalf("renderVCL() returned error: %v", err)
+	}
+
+	if !strings.Contains(vcl, `return (synth(503, "no Envoy backends available"));`) {
+		t.Errorf("expected a 503 fallback when there are no backends, got:\n%s", vcl)
+	}
+
+	if strings.Contains(vcl, "directors.round_robin()") {
+		t.Errorf("did not expect a director to be set up with no backends, got:\n%s", vcl)
+	}
+}
+
+func TestRenderVCLHostTTLOverride(t *testing.T) {
+	rules := []rule{
+		{Host: "a.example.com", TTL: "30s"},
+		{Host: "b.example.com"},
+	}
+
+	vcl, err := renderVCL(rules, []backend{{Name: "envoy0", IP: "10.0.0.1"}}, "120s")
+	if err != nil {
+		t.Fatalf("renderVCL() returned error: %v", err)
+	}
+
+	if !strings.Contains(vcl, `bereq.http.host == "a.example.com"`) || !strings.Contains(vcl, "set beresp.ttl = 30s;") {
+		t.Errorf("expected a.example.com to use its own TTL, got:\n%s", vcl)
+	}
+
+	if !strings.Contains(vcl, `bereq.http.host == "b.example.com"`) || !strings.Contains(vcl, "set beresp.ttl = 120s;") {
+		t.Errorf("expected b.example.com to fall back to the default TTL, got:\n%s", vcl)
+	}
+}
@@ -15,16 +15,30 @@
 package dns
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
 	"reflect"
 	"sort"
+	"strings"
+	"time"
 
-	"github.com/kinvolk/lokomotive/pkg/terraform"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/cloudflare/cloudflare-go"
 	"github.com/pkg/errors"
+
+	"github.com/kinvolk/lokomotive/pkg/terraform"
 )
 
+// route53SyncTimeout bounds how long Apply waits for a Route53 change batch to
+// reach INSYNC before giving up. Route53 propagation is normally well under a
+// minute; this only kicks in during an AWS incident or heavy throttling.
+const route53SyncTimeout = 10 * time.Minute
+
 const (
 	// DNSManual represents manual DNS configuration.
 	DNSManual = "manual"
@@ -39,6 +53,36 @@ type dnsEntry struct {
 	Ttl       int      `json:"ttl"`
 	EntryType string   `json:"type"`
 	Records   []string `json:"records"`
+	// AliasTarget is set instead of Records/Ttl for a Route53 alias record,
+	// e.g. an apex domain pointed at an ALB's DNS name.
+	AliasTarget *aliasTarget `json:"alias_target,omitempty"`
+}
+
+// aliasTarget is a Route53 alias target, as read from the Terraform
+// dns_entries output for an "alias" entry.
+type aliasTarget struct {
+	HostedZoneID         string `json:"hosted_zone_id"`
+	DNSName              string `json:"dns_name"`
+	EvaluateTargetHealth bool   `json:"evaluate_target_health"`
+}
+
+// Credentials holds the provider-specific credentials needed by Apply to reconcile
+// DNS entries programmatically.
+type Credentials struct {
+	Route53    *Route53Credentials
+	Cloudflare *CloudflareCredentials
+}
+
+// Route53Credentials are the AWS credentials used to reconcile a Route53 hosted zone.
+type Route53Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	Region          string
+}
+
+// CloudflareCredentials are the API credentials used to reconcile a Cloudflare zone.
+type CloudflareCredentials struct {
+	APIToken string
 }
 
 // Validate ensures the DNS provider p is a valid provider.
@@ -88,6 +132,284 @@ func AskToConfigure(ex *terraform.Executor, zone string) error {
 	return nil
 }
 
+// Apply reads the required DNS entries from a Terraform output and reconciles them
+// at the DNS provider directly, without requiring operator interaction. It falls
+// back to the interactive AskToConfigure flow when provider is DNSManual. ctx
+// bounds how long Apply waits for a Route53 change to reach INSYNC; canceling it
+// (e.g. on SIGINT) aborts the wait instead of hanging forever.
+func Apply(ctx context.Context, ex *terraform.Executor, zone, provider string, creds Credentials) error {
+	if provider == DNSManual {
+		return AskToConfigure(ex, zone)
+	}
+
+	dnsEntries, err := readDNSEntries(ex)
+	if err != nil {
+		return err
+	}
+
+	switch provider {
+	case DNSRoute53:
+		return applyRoute53(ctx, zone, dnsEntries, creds.Route53)
+	case DNSCloudflare:
+		return applyCloudflare(zone, dnsEntries, creds.Cloudflare)
+	default:
+		return fmt.Errorf("invalid DNS provider %q", provider)
+	}
+}
+
+func applyRoute53(ctx context.Context, zone string, entries []dnsEntry, creds *Route53Credentials) error {
+	if creds == nil {
+		return fmt.Errorf("route53 credentials must be set")
+	}
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String(creds.Region),
+		Credentials: credentials.NewStaticCredentials(creds.AccessKeyID, creds.SecretAccessKey, ""),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create AWS session")
+	}
+
+	svc := route53.New(sess)
+
+	zoneID, err := route53HostedZoneID(svc, zone)
+	if err != nil {
+		return errors.Wrapf(err, "looking up hosted zone %q", zone)
+	}
+
+	var changes []*route53.Change
+
+	for _, entry := range entries {
+		inSync, err := route53EntryInSync(svc, zoneID, entry)
+		if err != nil {
+			return errors.Wrapf(err, "comparing existing RRSet for %q", entry.Name)
+		}
+
+		if inSync {
+			continue
+		}
+
+		changes = append(changes, &route53.Change{
+			Action:            aws.String(route53.ChangeActionUpsert),
+			ResourceRecordSet: route53ResourceRecordSet(entry),
+		})
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	resp, err := svc.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(zoneID),
+		ChangeBatch: &route53.ChangeBatch{
+			Changes: changes,
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "submitting Route53 change batch")
+	}
+
+	syncCtx, cancel := context.WithTimeout(ctx, route53SyncTimeout)
+	defer cancel()
+
+	return waitForRoute53Sync(syncCtx, svc, *resp.ChangeInfo.Id)
+}
+
+func route53HostedZoneID(svc *route53.Route53, zone string) (string, error) {
+	out, err := svc.ListHostedZonesByName(&route53.ListHostedZonesByNameInput{
+		DNSName: aws.String(zone),
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "listing hosted zones")
+	}
+
+	for _, hz := range out.HostedZones {
+		if strings.TrimSuffix(*hz.Name, ".") == strings.TrimSuffix(zone, ".") {
+			return *hz.Id, nil
+		}
+	}
+
+	return "", fmt.Errorf("no hosted zone found for %q", zone)
+}
+
+func route53EntryInSync(svc *route53.Route53, zoneID string, entry dnsEntry) (bool, error) {
+	out, err := svc.ListResourceRecordSets(&route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(zoneID),
+		StartRecordName: aws.String(entry.Name),
+		StartRecordType: aws.String(entry.EntryType),
+		MaxItems:        aws.String("1"),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if len(out.ResourceRecordSets) == 0 {
+		return false, nil
+	}
+
+	existing := out.ResourceRecordSets[0]
+	if strings.TrimSuffix(*existing.Name, ".") != strings.TrimSuffix(entry.Name, ".") || *existing.Type != entry.EntryType {
+		return false, nil
+	}
+
+	if entry.AliasTarget != nil {
+		if existing.AliasTarget == nil {
+			return false, nil
+		}
+
+		return strings.TrimSuffix(aws.StringValue(existing.AliasTarget.DNSName), ".") == strings.TrimSuffix(entry.AliasTarget.DNSName, ".") &&
+			aws.StringValue(existing.AliasTarget.HostedZoneId) == entry.AliasTarget.HostedZoneID &&
+			aws.BoolValue(existing.AliasTarget.EvaluateTargetHealth) == entry.AliasTarget.EvaluateTargetHealth, nil
+	}
+
+	var existingRecords []string
+	for _, rr := range existing.ResourceRecords {
+		existingRecords = append(existingRecords, *rr.Value)
+	}
+
+	sort.Strings(existingRecords)
+
+	wantRecords := append([]string{}, entry.Records...)
+	sort.Strings(wantRecords)
+
+	return reflect.DeepEqual(existingRecords, wantRecords), nil
+}
+
+// route53ResourceRecordSet builds the RRSet for entry. An "alias" entry (e.g.
+// an apex domain pointed at an ALB) carries an AliasTarget instead of a
+// TTL/ResourceRecords pair; Route53 rejects a record that sets both.
+func route53ResourceRecordSet(entry dnsEntry) *route53.ResourceRecordSet {
+	rrs := &route53.ResourceRecordSet{
+		Name: aws.String(entry.Name),
+		Type: aws.String(entry.EntryType),
+	}
+
+	if entry.AliasTarget != nil {
+		rrs.AliasTarget = &route53.AliasTarget{
+			HostedZoneId:         aws.String(entry.AliasTarget.HostedZoneID),
+			DNSName:              aws.String(entry.AliasTarget.DNSName),
+			EvaluateTargetHealth: aws.Bool(entry.AliasTarget.EvaluateTargetHealth),
+		}
+
+		return rrs
+	}
+
+	rrs.TTL = aws.Int64(int64(entry.Ttl))
+
+	for _, record := range entry.Records {
+		rrs.ResourceRecords = append(rrs.ResourceRecords, &route53.ResourceRecord{
+			Value: aws.String(record),
+		})
+	}
+
+	return rrs
+}
+
+func waitForRoute53Sync(ctx context.Context, svc *route53.Route53, changeID string) error {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		out, err := svc.GetChange(&route53.GetChangeInput{
+			Id: aws.String(changeID),
+		})
+		if err != nil {
+			return errors.Wrap(err, "getting Route53 change status")
+		}
+
+		if *out.ChangeInfo.Status == route53.ChangeStatusInsync {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "waiting for Route53 change %q to sync", changeID)
+		case <-ticker.C:
+		}
+	}
+}
+
+func applyCloudflare(zone string, entries []dnsEntry, creds *CloudflareCredentials) error {
+	if creds == nil {
+		return fmt.Errorf("cloudflare credentials must be set")
+	}
+
+	api, err := cloudflare.NewWithAPIToken(creds.APIToken)
+	if err != nil {
+		return errors.Wrap(err, "creating Cloudflare API client")
+	}
+
+	zoneID, err := api.ZoneIDByName(zone)
+	if err != nil {
+		return errors.Wrapf(err, "looking up zone %q", zone)
+	}
+
+	for _, entry := range entries {
+		if err := upsertCloudflareEntry(api, zoneID, entry); err != nil {
+			return errors.Wrapf(err, "reconciling %q", entry.Name)
+		}
+	}
+
+	return nil
+}
+
+// upsertCloudflareEntry reconciles every record in entry.Records against the
+// existing records for entry.Name/entry.Type: matching records are updated in
+// place, missing ones are created and any existing record no longer wanted is
+// deleted. This mirrors how the Route53 path compares the full RRSet rather
+// than just the first value, so it stays idempotent for multi-record (HA)
+// entries.
+func upsertCloudflareEntry(api *cloudflare.API, zoneID string, entry dnsEntry) error {
+	existing, err := api.DNSRecords(zoneID, cloudflare.DNSRecord{
+		Name: entry.Name,
+		Type: entry.EntryType,
+	})
+	if err != nil {
+		return err
+	}
+
+	existingByContent := make(map[string]cloudflare.DNSRecord, len(existing))
+	for _, record := range existing {
+		existingByContent[record.Content] = record
+	}
+
+	for _, content := range entry.Records {
+		record, ok := existingByContent[content]
+		if !ok {
+			if _, err := api.CreateDNSRecord(zoneID, cloudflare.DNSRecord{
+				Type:    entry.EntryType,
+				Name:    entry.Name,
+				Content: content,
+				TTL:     entry.Ttl,
+			}); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		delete(existingByContent, content)
+
+		if record.TTL == entry.Ttl {
+			continue
+		}
+
+		record.TTL = entry.Ttl
+
+		if err := api.UpdateDNSRecord(zoneID, record.ID, record); err != nil {
+			return err
+		}
+	}
+
+	for _, stale := range existingByContent {
+		if err := api.DeleteDNSRecord(zoneID, stale.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func readDNSEntries(ex *terraform.Executor) ([]dnsEntry, error) {
 	output, err := ex.ExecuteSync("output", "-json", "dns_entries")
 	if err != nil {
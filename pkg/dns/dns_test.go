@@ -0,0 +1,66 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestRoute53ResourceRecordSet(t *testing.T) {
+	t.Run("plain record", func(t *testing.T) {
+		rrs := route53ResourceRecordSet(dnsEntry{
+			Name:      "www.example.com",
+			EntryType: "CNAME",
+			Ttl:       300,
+			Records:   []string{"lb.example.com"},
+		})
+
+		if rrs.AliasTarget != nil {
+			t.Fatalf("expected no AliasTarget on a plain record, got %+v", rrs.AliasTarget)
+		}
+
+		if aws.Int64Value(rrs.TTL) != 300 {
+			t.Errorf("TTL = %d, want 300", aws.Int64Value(rrs.TTL))
+		}
+
+		if len(rrs.ResourceRecords) != 1 || aws.StringValue(rrs.ResourceRecords[0].Value) != "lb.example.com" {
+			t.Errorf("unexpected ResourceRecords: %+v", rrs.ResourceRecords)
+		}
+	})
+
+	t.Run("alias record", func(t *testing.T) {
+		rrs := route53ResourceRecordSet(dnsEntry{
+			Name:      "example.com",
+			EntryType: "A",
+			AliasTarget: &aliasTarget{
+				HostedZoneID:         "Z123",
+				DNSName:              "my-alb-123456.us-east-1.elb.amazonaws.com",
+				EvaluateTargetHealth: true,
+			},
+		})
+
+		if rrs.TTL != nil {
+			t.Errorf("expected no TTL on an alias record, got %v", aws.Int64Value(rrs.TTL))
+		}
+
+		if len(rrs.ResourceRecords) != 0 {
+			t.Errorf("expected no ResourceRecords on an alias record, got %+v", rrs.ResourceRecords)
+		}
+
+		if rrs.AliasTarget == nil {
+			t.Fatal("expected an AliasTarget")
+		}
+
+		if aws.StringValue(rrs.AliasTarget.HostedZoneId) != "Z123" {
+			t.Errorf("HostedZoneId = %q, want %q", aws.StringValue(rrs.AliasTarget.HostedZoneId), "Z123")
+		}
+
+		if aws.StringValue(rrs.AliasTarget.DNSName) != "my-alb-123456.us-east-1.elb.amazonaws.com" {
+			t.Errorf("unexpected DNSName: %q", aws.StringValue(rrs.AliasTarget.DNSName))
+		}
+
+		if !aws.BoolValue(rrs.AliasTarget.EvaluateTargetHealth) {
+			t.Error("expected EvaluateTargetHealth to be true")
+		}
+	})
+}
@@ -0,0 +1,300 @@
+// Copyright 2020 The Lokomotive Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package openstack generates the Terraform configuration and manifests for a
+// Lokomotive cluster hosted on OpenStack: Nova instances for the control
+// plane and workers, Neutron/Octavia for load balancing and the Cinder CSI
+// driver for persistent volumes.
+package openstack
+
+import (
+	"encoding/json"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/pkg/errors"
+
+	"github.com/kinvolk/lokomotive/pkg/platform"
+	"github.com/kinvolk/lokomotive/pkg/terraform"
+	utilpkg "github.com/kinvolk/lokomotive/pkg/util"
+)
+
+const name = "openstack"
+
+func init() {
+	platform.Register(name, NewConfig())
+}
+
+const terraformTmpl = `
+provider "openstack" {
+  auth_url                     = "{{ .AuthURL }}"
+  region                       = "{{ .Region }}"
+  tenant_id                    = "{{ .ProjectID }}"
+  application_credential_id     = "{{ .ApplicationCredentialID }}"
+  application_credential_secret = "{{ .ApplicationCredentialSecret }}"
+}
+
+resource "openstack_compute_instance_v2" "controller" {
+  count       = {{ .ControllerCount }}
+  name        = "{{ .ClusterName }}-controller-${count.index}"
+  flavor_name = "{{ .ControllerFlavor }}"
+  image_name  = "{{ .Image }}"
+}
+
+{{ range .WorkerPools }}
+resource "openstack_compute_instance_v2" "{{ .Name }}" {
+  count       = {{ .Count }}
+  name        = "{{ $.ClusterName }}-{{ .Name }}-${count.index}"
+  flavor_name = "{{ .Flavor }}"
+  image_name  = "{{ $.Image }}"
+}
+{{ end }}
+
+resource "openstack_lb_loadbalancer_v2" "apiserver" {
+  name          = "{{ .ClusterName }}-apiserver"
+  vip_subnet_id = "{{ .SubnetID }}"
+}
+
+resource "openstack_lb_listener_v2" "apiserver" {
+  name            = "{{ .ClusterName }}-apiserver"
+  protocol        = "TCP"
+  protocol_port   = 6443
+  loadbalancer_id = openstack_lb_loadbalancer_v2.apiserver.id
+}
+
+resource "openstack_lb_pool_v2" "apiserver" {
+  name        = "{{ .ClusterName }}-apiserver"
+  protocol    = "TCP"
+  lb_method   = "ROUND_ROBIN"
+  listener_id = openstack_lb_listener_v2.apiserver.id
+}
+
+resource "openstack_lb_member_v2" "controller" {
+  count         = {{ .ControllerCount }}
+  pool_id       = openstack_lb_pool_v2.apiserver.id
+  address       = openstack_compute_instance_v2.controller[count.index].access_ip_v4
+  protocol_port = 6443
+}
+
+# openstack_cloud_config is read back by ReadCloudConfig and embedded into the
+# Cinder CSI driver's cloud-config Secret, so the in-cluster config always
+# matches what this module actually applied instead of being recomputed from
+# the "openstack" block's HCL fields.
+output "openstack_cloud_config" {
+  value = <<-EOT
+    [Global]
+    auth-url={{ .AuthURL }}
+    region={{ .Region }}
+    tenant-id={{ .ProjectID }}
+    application-credential-id={{ .ApplicationCredentialID }}
+    application-credential-secret={{ .ApplicationCredentialSecret }}
+  EOT
+  sensitive = true
+}
+`
+
+// cinderCSIManifestsTmpl renders the cloud-config Secret and default
+// StorageClass the Cinder CSI driver needs to provision Cinder volumes as
+// PersistentVolumes. It is returned by RenderManifests alongside the
+// component manifests. CloudConfig is read back from the "openstack_cloud_config"
+// Terraform output by ReadCloudConfig, rather than recomputed from the
+// "openstack" block's HCL fields, so the Secret always matches what was
+// actually applied.
+const cinderCSIManifestsTmpl = `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: cloud-config
+  namespace: kube-system
+stringData:
+  cloud.conf: {{ printf "%q" .CloudConfig }}
+---
+apiVersion: storage.k8s.io/v1
+kind: StorageClass
+metadata:
+  name: cinder-csi
+  annotations:
+    storageclass.kubernetes.io/is-default-class: "true"
+provisioner: cinder.csi.openstack.org
+`
+
+// config holds the configuration for an OpenStack-hosted Lokomotive cluster.
+type config struct {
+	// required parameters
+	ClusterName string `hcl:"cluster_name,optional"`
+	AuthURL     string `hcl:"auth_url,optional"`
+	Region      string `hcl:"region,optional"`
+	ProjectID   string `hcl:"project_id,optional"`
+	SubnetID    string `hcl:"subnet_id,optional"`
+	Image       string `hcl:"image,optional"`
+
+	// application credential pair used instead of a username/password.
+	ApplicationCredentialID     string `hcl:"application_credential_id,optional"`
+	ApplicationCredentialSecret string `hcl:"application_credential_secret,optional"`
+
+	// optional parameters
+	ControllerCount  int    `hcl:"controller_count,optional"`
+	ControllerFlavor string `hcl:"controller_flavor,optional"`
+
+	WorkerPools []workerPool `hcl:"worker_pool,block"`
+
+	// CloudConfig is set from ReadCloudConfig before rendering
+	// cinderCSIManifestsTmpl, so the template can reference it without a
+	// method call.
+	CloudConfig string
+}
+
+type workerPool struct {
+	Name   string `hcl:"name,label"`
+	Count  int    `hcl:"count,optional"`
+	Flavor string `hcl:"flavor,optional"`
+}
+
+// NewConfig returns a config with Lokomotive's OpenStack defaults.
+func NewConfig() *config {
+	return &config{
+		ControllerCount:  1,
+		ControllerFlavor: "m1.medium",
+	}
+}
+
+// LoadConfig decodes the HCL configuration for the "openstack" platform block.
+func (c *config) LoadConfig(configBody *hcl.Body, evalContext *hcl.EvalContext) hcl.Diagnostics {
+	diagnostics := hcl.Diagnostics{}
+
+	if configBody == nil {
+		diagnostics = append(diagnostics, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "platform requires configuration",
+			Detail:   "the 'openstack' platform block must be defined",
+		})
+
+		return diagnostics
+	}
+
+	if err := gohcl.DecodeBody(*configBody, evalContext, c); err != nil {
+		diagnostics = append(diagnostics, err...)
+	}
+
+	diagnostics = append(diagnostics, c.validate()...)
+
+	return diagnostics
+}
+
+func (c *config) validate() hcl.Diagnostics {
+	diagnostics := hcl.Diagnostics{}
+
+	if c.ClusterName == "" {
+		diagnostics = append(diagnostics, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "'cluster_name' must be set",
+			Detail:   "'cluster_name' must be set but it was not found",
+		})
+	}
+
+	if c.AuthURL == "" {
+		diagnostics = append(diagnostics, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "'auth_url' must be set",
+			Detail:   "'auth_url' must be set but it was not found",
+		})
+	}
+
+	if c.ApplicationCredentialID == "" || c.ApplicationCredentialSecret == "" {
+		diagnostics = append(diagnostics, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "application credentials must be set",
+			Detail:   "'application_credential_id' and 'application_credential_secret' must both be set",
+		})
+	}
+
+	if c.Region == "" {
+		diagnostics = append(diagnostics, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "'region' must be set",
+			Detail:   "'region' must be set but it was not found",
+		})
+	}
+
+	if c.SubnetID == "" {
+		diagnostics = append(diagnostics, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "'subnet_id' must be set",
+			Detail:   "'subnet_id' must be set but it was not found, and is required to place the apiserver load balancer",
+		})
+	}
+
+	if len(c.WorkerPools) == 0 {
+		diagnostics = append(diagnostics, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "at least one 'worker_pool' block must be set",
+			Detail:   "at least one 'worker_pool' block must be set",
+		})
+	}
+
+	return diagnostics
+}
+
+// Name returns the platform name, as used in the cluster configuration's "platform" block label.
+func Name() string {
+	return name
+}
+
+// RenderTerraform renders the Terraform configuration for the Nova instances, the
+// Octavia-backed API server load balancer and the supporting Neutron resources.
+func (c *config) RenderTerraform() (string, error) {
+	return utilpkg.RenderTemplate(terraformTmpl, c)
+}
+
+// RenderManifests renders the Kubernetes manifests the OpenStack platform
+// needs in-cluster: the Cinder CSI driver's cloud-config Secret and default
+// StorageClass. Service LoadBalancers are handled by the cloud-provider-openstack
+// controller reading Octavia annotations on the Service itself, so there is no
+// separate load balancer manifest to render here.
+func (c *config) RenderManifests(ex *terraform.Executor) (map[string]string, error) {
+	cloudConfig, err := ReadCloudConfig(ex)
+	if err != nil {
+		return nil, err
+	}
+
+	c.CloudConfig = cloudConfig
+
+	manifest, err := utilpkg.RenderTemplate(cinderCSIManifestsTmpl, c)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{"cinder-csi.yaml": manifest}, nil
+}
+
+// ReadCloudConfig reads the "openstack_cloud_config" output from the
+// cluster's already-applied Terraform module, mirroring how
+// pkg/dns.readDNSEntries reads the "dns_entries" output: the cloud-config
+// content is generated once in terraformTmpl and read back here, instead of
+// being recomputed from the "openstack" block's HCL fields, so it always
+// matches what Terraform actually applied.
+func ReadCloudConfig(ex *terraform.Executor) (string, error) {
+	output, err := ex.ExecuteSync("output", "-json", "openstack_cloud_config")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get OpenStack cloud-config")
+	}
+
+	var cloudConfig string
+
+	if err := json.Unmarshal(output, &cloudConfig); err != nil {
+		return "", errors.Wrap(err, "failed to parse OpenStack cloud-config")
+	}
+
+	return cloudConfig, nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2020 The Lokomotive Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package platform holds the registry of infrastructure platforms Lokomotive
+// can stand a cluster up on (e.g. Packet, AWS, OpenStack). Platforms register
+// themselves from their own package's init(), mirroring how pkg/components
+// registers components.
+package platform
+
+import (
+	"github.com/hashicorp/hcl/v2"
+
+	"github.com/kinvolk/lokomotive/pkg/terraform"
+)
+
+// Platform generates the Terraform configuration, and any supporting
+// manifests, needed to stand up a Lokomotive cluster on a given
+// infrastructure provider.
+type Platform interface {
+	LoadConfig(configBody *hcl.Body, evalContext *hcl.EvalContext) hcl.Diagnostics
+	RenderTerraform() (string, error)
+	// RenderManifests renders the in-cluster manifests the platform needs.
+	// ex is the Executor for the cluster's already-applied Terraform module,
+	// so a platform can source manifest values (e.g. a cloud-config secret)
+	// from real Terraform outputs instead of recomputing them from HCL input,
+	// the same way pkg/dns reads the "dns_entries" output.
+	RenderManifests(ex *terraform.Executor) (map[string]string, error)
+}
+
+var platforms = map[string]Platform{}
+
+// Register registers a Platform under name, so it can be looked up by the
+// "platform" block label in the cluster configuration.
+func Register(name string, p Platform) {
+	platforms[name] = p
+}
+
+// Get returns the Platform registered under name, and whether it was found.
+func Get(name string) (Platform, bool) {
+	p, ok := platforms[name]
+
+	return p, ok
+}